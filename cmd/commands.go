@@ -0,0 +1,556 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"go-url-shortener/db/migrations"
+	"go-url-shortener/internal/api/handler"
+	"go-url-shortener/internal/backup"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/transfer"
+	"go-url-shortener/internal/utils"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// command is an admin subcommand, it works on the configured database directly
+type command struct {
+	usage   string
+	summary string
+	run     func(ctx context.Context, c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"shorten":    {"shorten [-domain host] [-owner owner] [-title title] [-tags a,b] [-expires time] URL", "Shorten a URL", shortenCommand},
+	"get":        {"get [-domain host] KEY", "Show the details of a link", getCommand},
+	"list":       {"list [-domain host] [-owner owner] [-tag tag] [-q text] [-limit n] [-offset n]", "List links, newest first", listCommand},
+	"deactivate": {"deactivate [-domain host] KEY...", "Deactivate links, they stop redirecting", deactivateCommand},
+	"stats":      {"stats [-domain host] [-top n]", "Show link and click counts and the most clicked links", statsCommand},
+	"export":     {"export [-format ndjson|csv] [-o file]", "Export every link", exportCommand},
+	"import":     {"import [-format ndjson|csv|bitly|yourls] [-domain host] [-dry-run] [-i file]", "Import links, keeping their keys", importCommand},
+	"backup":     {"backup [-o file]", "Back up the database while it is in use", backupCommand},
+	"restore":    {"restore FILE", "Replace the database with a backup, the server must be stopped", restoreCommand},
+	"migrate":    {"migrate", "Apply the pending database migrations", migrateCommand},
+}
+
+// offline commands run without opening the database
+var offline = map[string]bool{"restore": true}
+
+// errUsage is returned for invalid arguments, the usage of the command is printed
+var errUsage = errors.New("invalid arguments")
+
+// statsPageSize is how many links are read at once when walking the links of a domain
+const statsPageSize = 500
+
+// cli is what the commands work with
+type cli struct {
+	urls    models.ShortenerDataInterface
+	domains models.DomainInterface
+	links   *handler.LinkBuilder
+	// db takes the backups, restore replaces the file at dbPath
+	db     *sql.DB
+	dbPath string
+	// schema is the write pool, migrate changes the schema through it
+	schema *sql.DB
+	// backups is the configured backup directory, nil when there is none
+	backups *backup.Dir
+	// host is used for the short URLs of the default domain when no base URL is configured
+	host string
+	in   io.Reader
+	out  io.Writer
+	err  io.Writer
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] [command] [arguments]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands)+1)
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(out, "  %-11s %s\n", "serve", "Run the HTTP server, the default")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-11s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// runCommand runs an admin command and returns the exit code
+func runCommand(cfg *config, name string, args []string) int {
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		return 2
+	}
+
+	c := &cli{
+		links:  &handler.LinkBuilder{BaseURL: cfg.baseURL, Prefix: cfg.redirectPrefix},
+		dbPath: cfg.dbPath,
+		host:   cfg.host(),
+		in:     os.Stdin,
+		out:    os.Stdout,
+		err:    os.Stderr,
+	}
+	if !offline[name] {
+		db, err := openDB(cfg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer db.Close()
+		c.db, c.schema = db.Read, db.Write
+		c.urls = &models.ShortenerDBModel{DB: db.Write, ReadDB: db.Read, QueryTimeout: cfg.queryTimeout}
+		c.domains = &models.DomainDBModel{DB: db.Write, ReadDB: db.Read, QueryTimeout: cfg.queryTimeout}
+		if cfg.backupDir != "" {
+			c.backups = &backup.Dir{DB: db.Read, Path: cfg.backupDir, Keep: cfg.backupKeep}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	switch err := cmd.run(ctx, c, args); {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "%v\nUsage: %s %s\n", err, os.Args[0], cmd.usage)
+		return 2
+	default:
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+}
+
+// flags creates the flag set of a command, every command takes the domain whose namespace it works in
+func (c *cli) flags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	domain := fs.String("domain", "", "Host of the branded domain, the default namespace when empty")
+	return fs, domain
+}
+
+// shortURL builds the short URL of a key like the API does for a request to the server
+func (c *cli) shortURL(domain, key string) string {
+	r := &http.Request{Host: c.host, Header: http.Header{}}
+	return c.links.ShortURL(r, domain, key)
+}
+
+func shortenCommand(ctx context.Context, c *cli, args []string) error {
+	fs, domain := c.flags("shorten")
+	owner := fs.String("owner", "", "Owner of the link")
+	title := fs.String("title", "", "Title of the link")
+	notes := fs.String("notes", "", "Notes about the link")
+	tagList := fs.String("tags", "", "Comma separated tags")
+	expires := fs.String("expires", "", "When the link expires, an RFC 3339 time")
+	interstitial := fs.Bool("interstitial", false, `Show a "you are leaving" page before redirecting`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	link := &models.ShortenerData{
+		Domain:       utils.NormalizeHost(*domain),
+		Owner:        strings.TrimSpace(*owner),
+		OriginalURL:  fs.Arg(0),
+		Title:        strings.TrimSpace(*title),
+		Notes:        *notes,
+		Interstitial: *interstitial,
+	}
+	if *tagList != "" {
+		link.Tags = strings.Split(*tagList, ",")
+	}
+	if *expires != "" {
+		expiresAt, err := time.Parse(time.RFC3339, *expires)
+		if err != nil {
+			return fmt.Errorf("expiry must be an RFC 3339 time: %w", errUsage)
+		}
+		if !expiresAt.After(time.Now()) {
+			return errors.New("expiry must be in the future")
+		}
+		link.ExpiresAt = expiresAt
+	}
+	// unlike the shorten endpoint the destination isn't contacted so links can be created offline
+	if err := handler.ValidateLink(ctx, c.domains, link); err != nil {
+		return err
+	}
+
+	key, msg, err := c.urls.Insert(ctx, link)
+	if err != nil {
+		return err
+	}
+	if msg != models.MsgShortened {
+		fmt.Fprintln(c.err, msg)
+	}
+	fmt.Fprintln(c.out, c.shortURL(link.Domain, key))
+	return nil
+}
+
+// keyArgs parses the flags of a command taking keys, at least one key is required
+func keyArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() == 0 {
+		return nil, errUsage
+	}
+	for _, key := range fs.Args() {
+		if !utils.IsValidURLKey(key) {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+	}
+	return fs.Args(), nil
+}
+
+func getCommand(ctx context.Context, c *cli, args []string) error {
+	fs, domain := c.flags("get")
+	keys, err := keyArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(keys) != 1 {
+		return errUsage
+	}
+
+	data, err := c.urls.Get(ctx, utils.NormalizeHost(*domain), keys[0])
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Short URL:\t%s\n", c.shortURL(data.Domain, data.ShortenedURLKEY))
+	fmt.Fprintf(tw, "Original URL:\t%s\n", data.OriginalURL)
+	fmt.Fprintf(tw, "Status:\t%s\n", status(data, time.Now()))
+	fmt.Fprintf(tw, "Clicks:\t%d\n", data.Clicks)
+	fmt.Fprintf(tw, "Owner:\t%s\n", data.Owner)
+	fmt.Fprintf(tw, "Title:\t%s\n", data.Title)
+	fmt.Fprintf(tw, "Tags:\t%s\n", strings.Join(data.Tags, ", "))
+	if !data.ExpiresAt.IsZero() {
+		fmt.Fprintf(tw, "Expires:\t%s\n", data.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if data.Notes != "" {
+		fmt.Fprintf(tw, "Notes:\t%s\n", data.Notes)
+	}
+	return tw.Flush()
+}
+
+// status describes whether a link redirects
+func status(data *models.ShortenerData, now time.Time) string {
+	switch {
+	case data.Deactivated:
+		return "deactivated"
+	case data.IsExpired(now):
+		return "expired"
+	default:
+		return "active"
+	}
+}
+
+func listCommand(ctx context.Context, c *cli, args []string) error {
+	fs, domain := c.flags("list")
+	owner := fs.String("owner", "", "Only list the links of this owner")
+	tag := fs.String("tag", "", "Only list the links with this tag")
+	search := fs.String("q", "", "Search the title, notes and original URL")
+	limit := fs.Int("limit", models.DefaultListLimit, "How many links to list")
+	offset := fs.Int("offset", 0, "How many links to skip")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *limit <= 0 || *offset < 0 {
+		return errUsage
+	}
+
+	links, err := c.urls.List(ctx, &models.ListFilter{
+		Domain: utils.NormalizeHost(*domain),
+		Owner:  *owner,
+		Tag:    utils.NormalizeTag(*tag),
+		Search: *search,
+		Limit:  *limit,
+		Offset: *offset,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tCLICKS\tSTATUS\tOWNER\tORIGINAL URL")
+	for _, data := range links {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", data.ShortenedURLKEY, data.Clicks, status(data, now), data.Owner, data.OriginalURL)
+	}
+	return tw.Flush()
+}
+
+func deactivateCommand(ctx context.Context, c *cli, args []string) error {
+	fs, domain := c.flags("deactivate")
+	keys, err := keyArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if _, err = c.urls.Deactivate(ctx, utils.NormalizeHost(*domain), key); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		fmt.Fprintf(c.out, "Deactivated %s\n", key)
+	}
+	return nil
+}
+
+// eachLink calls fn with every link of a domain, page by page
+func (c *cli) eachLink(ctx context.Context, domain string, fn func(*models.ShortenerData) error) error {
+	filter := &models.ListFilter{Domain: domain, Limit: statsPageSize}
+	for {
+		links, err := c.urls.List(ctx, filter)
+		if err != nil {
+			return err
+		}
+		for _, data := range links {
+			if err = fn(data); err != nil {
+				return err
+			}
+		}
+		if len(links) < filter.Limit {
+			return nil
+		}
+		filter.Offset += len(links)
+	}
+}
+
+func statsCommand(ctx context.Context, c *cli, args []string) error {
+	fs, domain := c.flags("stats")
+	top := fs.Int("top", 10, "How many of the most clicked links to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *top < 0 {
+		return errUsage
+	}
+
+	now := time.Now()
+	counts := map[string]int{}
+	var total, clicks int
+	var mostClicked []*models.ShortenerData
+	err := c.eachLink(ctx, utils.NormalizeHost(*domain), func(data *models.ShortenerData) error {
+		total++
+		clicks += data.Clicks
+		counts[status(data, now)]++
+		mostClicked = append(mostClicked, data)
+		sort.SliceStable(mostClicked, func(i, j int) bool { return mostClicked[i].Clicks > mostClicked[j].Clicks })
+		if len(mostClicked) > *top {
+			mostClicked = mostClicked[:*top]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Links:\t%d\n", total)
+	fmt.Fprintf(tw, "Active:\t%d\n", counts["active"])
+	fmt.Fprintf(tw, "Expired:\t%d\n", counts["expired"])
+	fmt.Fprintf(tw, "Deactivated:\t%d\n", counts["deactivated"])
+	fmt.Fprintf(tw, "Clicks:\t%d\n", clicks)
+	if len(mostClicked) > 0 {
+		fmt.Fprintln(tw, "\nKEY\tCLICKS\tORIGINAL URL")
+		for _, data := range mostClicked {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", data.ShortenedURLKEY, data.Clicks, data.OriginalURL)
+		}
+	}
+	return tw.Flush()
+}
+
+func exportCommand(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	format := fs.String("format", transfer.FormatNDJSON, "Format of the export, ndjson or csv")
+	output := fs.String("o", "-", `File to write, "-" writes to the standard output`)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || !slices.Contains(transfer.ExportFormats, *format) {
+		return errUsage
+	}
+
+	out := c.out
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	buffered := bufio.NewWriter(out)
+	w, err := transfer.NewWriter(buffered, *format)
+	if err != nil {
+		return err
+	}
+	n := 0
+	err = c.urls.Export(ctx, func(data *models.ShortenerData) error {
+		n++
+		return w.Write(transfer.FromLink(data))
+	})
+	if err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = buffered.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.err, "Exported %d links\n", n)
+	return nil
+}
+
+func importCommand(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	domain := fs.String("domain", "", "Import every link into the namespace of this domain instead of the domain of its record")
+	format := fs.String("format", transfer.FormatNDJSON, "Format of the import, ndjson, csv, bitly or yourls")
+	input := fs.String("i", "-", `File to read, "-" reads the standard input`)
+	dryRun := fs.Bool("dry-run", false, "Report what would be imported without importing anything")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || !slices.Contains(transfer.ImportFormats, *format) {
+		return errUsage
+	}
+	moveDomain := false
+	fs.Visit(func(f *flag.Flag) { moveDomain = moveDomain || f.Name == "domain" })
+
+	in := c.in
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	reader, err := transfer.NewReader(bufio.NewReader(in), *format)
+	if err != nil {
+		return err
+	}
+
+	links := []*models.ShortenerData{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		link := record.Link()
+		if moveDomain {
+			link.Domain = *domain
+		}
+		link.Domain = utils.NormalizeHost(link.Domain)
+		if err = handler.ValidateLink(ctx, c.domains, link); err != nil {
+			return fmt.Errorf("record %d: %w", len(links)+1, err)
+		}
+		links = append(links, link)
+	}
+
+	result, err := c.urls.Import(ctx, links, *dryRun)
+	if err != nil {
+		return err
+	}
+	for _, conflict := range result.Conflicts {
+		fmt.Fprintf(c.err, "record %d: %s: %s (%s)\n", conflict.Index+1, conflict.Key, conflict.Reason, conflict.Existing)
+	}
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+	fmt.Fprintf(c.out, "%s %d links, skipped %d conflicts\n", verb, result.Imported, len(result.Conflicts))
+	return nil
+}
+
+func backupCommand(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	output := fs.String("o", "", "File to write the backup to, a new file in the backup directory when empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	var info *backup.Info
+	var err error
+	switch {
+	case *output != "":
+		info, err = backup.Backup(ctx, c.db, *output)
+	case c.backups != nil:
+		info, err = c.backups.Create(ctx)
+	default:
+		return fmt.Errorf("no backup directory is configured, set -backup-dir or -o: %w", errUsage)
+	}
+	if info == nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Backed up the database to %s (%d bytes)\n", info.Path, info.Size)
+	return err
+}
+
+func restoreCommand(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errUsage
+	}
+
+	previous, err := backup.Restore(ctx, fs.Arg(0), c.dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "Restored %s from %s\n", c.dbPath, fs.Arg(0))
+	if previous != "" {
+		fmt.Fprintf(c.out, "The replaced database was kept as %s\n", previous)
+	}
+	return nil
+}
+
+func migrateCommand(ctx context.Context, c *cli, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(c.err)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	applied, err := models.Migrate(ctx, c.schema, migrations.FS)
+	for _, name := range applied {
+		fmt.Fprintf(c.out, "Applied %s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "The database is at schema version %d\n", models.SchemaVersion)
+	return nil
+}
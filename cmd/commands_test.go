@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/api/handler"
+	"go-url-shortener/internal/backup"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/models/mocks"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestCLI(in string) (*cli, *bytes.Buffer) {
+	out := &bytes.Buffer{}
+	return &cli{
+		urls:    mocks.MockDB(),
+		domains: &mocks.MockDomains{MockData: map[string]*models.Domain{}},
+		links:   &handler.LinkBuilder{BaseURL: &url.URL{Scheme: "https", Host: "sho.rt"}, Prefix: handler.DefaultPrefix},
+		host:    "localhost:8080",
+		in:      strings.NewReader(in),
+		out:     out,
+		err:     &bytes.Buffer{},
+	}, out
+}
+
+func TestCommands(t *testing.T) {
+	testCases := []struct {
+		name    string
+		args    []string
+		in      string
+		wantErr error
+		want    string
+	}{
+		{
+			name: "Shorten a URL",
+			args: []string{"shorten", "-tags", "Campaign", "https://amazon.com/"},
+			want: "https://sho.rt/s/abcabc1234567890\n",
+		},
+		{
+			name:    "Shorten an invalid URL",
+			args:    []string{"shorten", "amazon"},
+			wantErr: errors.New("Invalid URL"),
+		},
+		{
+			name:    "Shorten on an unknown domain",
+			args:    []string{"shorten", "-domain", "go.example.com", "https://amazon.com/"},
+			wantErr: errors.New(`Unknown domain "go.example.com"`),
+		},
+		{
+			name:    "Shorten without a URL",
+			args:    []string{"shorten"},
+			wantErr: errUsage,
+		},
+		{
+			name: "Get a link",
+			args: []string{"get", "abcabc1234567890"},
+			want: "Clicks:        19\n",
+		},
+		{
+			name:    "Get an unknown link",
+			args:    []string{"get", "abcabc1234567999"},
+			wantErr: models.ErrNotFound,
+		},
+		{
+			name: "List the links",
+			args: []string{"list"},
+			want: "abcabc1234567890  19      active         https://github.com/\n",
+		},
+		{
+			name: "Deactivate a link",
+			args: []string{"deactivate", "abcabc1234567890"},
+			want: "Deactivated abcabc1234567890\n",
+		},
+		{
+			name: "Stats",
+			args: []string{"stats", "-top", "1"},
+			want: "Clicks:       19\n",
+		},
+		{
+			name: "Export",
+			args: []string{"export"},
+			want: `{"domain":"","key":"abcabc1234567890","original_url":"https://github.com/","clicks":19,"active":true}`,
+		},
+		{
+			name: "Export as CSV",
+			args: []string{"export", "-format", "csv"},
+			want: ",abcabc1234567890,https://github.com/,,,,,19,true,false,,,,,,,,\n",
+		},
+		{
+			name: "Import keeping the keys",
+			args: []string{"import"},
+			in:   `{"key":"3xYzAb1","original_url":"https://example.com/new"}` + "\n" + `{"original_url":"https://google.com/"}`,
+			want: "Imported 1 links, skipped 1 conflicts\n",
+		},
+		{
+			name: "Import a Bitly export",
+			args: []string{"import", "-format", "bitly", "-dry-run"},
+			in:   "Title,Bitlink,Long URL,Created,Clicks\nNew,https://bit.ly/3xYzAb1,https://example.com/new,2023-05-01 10:11:12,7\n",
+			want: "Would import 1 links, skipped 0 conflicts\n",
+		},
+		{
+			name:    "Import an unknown format",
+			args:    []string{"import", "-format", "xml"},
+			wantErr: errUsage,
+		},
+		{
+			name:    "Import the key of a route",
+			args:    []string{"import"},
+			in:      `{"key":"Healthz","original_url":"https://example.com/new"}`,
+			wantErr: errors.New(`record 1: Key "Healthz" is the name of a route`),
+		},
+		{
+			name:    "Import an invalid record",
+			args:    []string{"import"},
+			in:      `{"original_url":"https://amazon.com/","tags":["no spaces"]}`,
+			wantErr: errors.New(`record 1: Invalid tag "no spaces"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, out := newTestCLI(tc.in)
+			err := commands[tc.args[0]].run(context.Background(), c, tc.args[1:])
+			if tc.wantErr != nil {
+				if err == nil || (!errors.Is(err, tc.wantErr) && err.Error() != tc.wantErr.Error()) {
+					t.Errorf("got error %v; want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), tc.want) {
+				t.Errorf("got %s; want %s", out.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestBackupCommands(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "database.db")
+	db, err := openDB(&config{dbPath: dbPath, pragmas: models.DefaultPragmas, readConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Write.Exec(fmt.Sprintf(`CREATE TABLE urls (url_id INTEGER PRIMARY KEY); PRAGMA user_version = %d`, models.SchemaVersion))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, out := newTestCLI("")
+	c.db, c.dbPath = db.Read, dbPath
+	ctx := context.Background()
+	if err = commands["backup"].run(ctx, c, nil); !errors.Is(err, errUsage) {
+		t.Errorf("got error %v; want the backup directory to be required", err)
+	}
+
+	c.backups = &backup.Dir{DB: db.Read, Path: filepath.Join(dir, "backups")}
+	if err = commands["backup"].run(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	backups, err := c.backups.List()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("got %d backups, error %v; want 1", len(backups), err)
+	}
+
+	// the server is stopped before a restore
+	db.Close()
+	out.Reset()
+	if err = commands["restore"].run(ctx, c, []string{backups[0].Path}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "The replaced database was kept as " + dbPath + ".pre-restore-"; !strings.Contains(out.String(), want) {
+		t.Errorf("got %s; want %s", out.String(), want)
+	}
+	if err = commands["restore"].run(ctx, c, []string{filepath.Join(dir, "missing.db")}); err == nil {
+		t.Error("restored a missing backup")
+	}
+}
+
+func TestMigrateCommand(t *testing.T) {
+	db, err := openDB(&config{dbPath: filepath.Join(t.TempDir(), "database.db"), pragmas: models.DefaultPragmas, readConns: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c, out := newTestCLI("")
+	c.schema = db.Write
+	ctx := context.Background()
+	if err = commands["migrate"].run(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "Applied 202405191609_create_urls_table.sql\n"; !strings.HasPrefix(out.String(), want) {
+		t.Errorf("got %s; want it to start with %s", out.String(), want)
+	}
+
+	// a migrated database is left as it is
+	out.Reset()
+	if err = commands["migrate"].run(ctx, c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("The database is at schema version %d\n", models.SchemaVersion); out.String() != want {
+		t.Errorf("got %q; want %q", out.String(), want)
+	}
+}
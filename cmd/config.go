@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go-url-shortener/internal/api/handler"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/proxy"
+	"go-url-shortener/internal/tracing"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config holds the settings of the server, every flag defaults to an environment variable
+type config struct {
+	addr string
+	// grpcAddr is the address of the gRPC API, empty disables it
+	grpcAddr string
+	dbPath   string
+	pragmas  models.Pragmas
+	// readConns is the size of the pool of read connections, writes go through a single connection
+	readConns      int
+	baseURL        *url.URL
+	redirectPrefix string
+	trustedProxies proxy.Proxies
+	// tokens authenticate the callers of the API
+	tokens        *auth.Tokens
+	logFormat     string
+	traceExporter string
+	// queryTimeout bounds every database operation
+	queryTimeout time.Duration
+	// drainDelay is how long readiness fails before the server stops accepting requests
+	drainDelay      time.Duration
+	shutdownTimeout time.Duration
+	// backupDir holds the backups taken by the API and the schedule, empty disables them
+	backupDir      string
+	backupInterval time.Duration
+	backupKeep     int
+}
+
+// host is the host the short URLs are built for when there is no base URL, the HTTP
+// address with localhost when it has no host
+func (cfg *config) host() string {
+	if strings.HasPrefix(cfg.addr, ":") {
+		return "localhost" + cfg.addr
+	}
+	return cfg.addr
+}
+
+// envOr returns the environment variable or the fallback when it is not set
+func envOr(name, fallback string) string {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return fallback
+}
+
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	defaultDBPath, _ := filepath.Abs("db/migrations/database.db")
+
+	flag.StringVar(&cfg.addr, "addr", ":"+envOr("PORT", "8080"), "HTTP network address")
+	grpcAddr := ""
+	if port, ok := os.LookupEnv("GRPC_PORT"); ok && port != "" {
+		grpcAddr = ":" + port
+	}
+	flag.StringVar(&cfg.grpcAddr, "grpc-addr", grpcAddr, "gRPC network address, the gRPC API is disabled when empty")
+	flag.StringVar(&cfg.dbPath, "db", envOr("DATABASE_PATH", defaultDBPath), "Path of the SQLite database")
+	flag.StringVar(&cfg.pragmas.JournalMode, "db-journal-mode", envOr("DB_JOURNAL_MODE", models.DefaultPragmas.JournalMode),
+		"Journal mode of the database, WAL lets reads run while a write is in progress")
+	busyTimeout := flag.String("db-busy-timeout", envOr("DB_BUSY_TIMEOUT", models.DefaultPragmas.BusyTimeout.String()),
+		"How long a statement waits for a lock held by another connection, such as a command run next to the server")
+	flag.StringVar(&cfg.pragmas.Synchronous, "db-synchronous", envOr("DB_SYNCHRONOUS", models.DefaultPragmas.Synchronous),
+		"How often the database syncs to disk: OFF, NORMAL or FULL")
+	foreignKeys := flag.String("db-foreign-keys", envOr("DB_FOREIGN_KEYS", strconv.FormatBool(models.DefaultPragmas.ForeignKeys)),
+		"Enforce the foreign keys of the schema")
+	readConns := flag.String("db-read-conns", envOr("DB_READ_CONNS", "4"), "Size of the pool of read connections")
+	baseURL := flag.String("base-url", os.Getenv("BASE_URL"), "Canonical public URL of the service, e.g. https://sho.rt")
+	flag.StringVar(&cfg.redirectPrefix, "redirect-prefix", envOr("REDIRECT_PREFIX", handler.DefaultPrefix),
+		`Path the redirects are served under, "/" serves the keys at the root`)
+	trustedProxies := flag.String("trusted-proxies", os.Getenv("TRUSTED_PROXIES"),
+		"Comma separated IPs and CIDRs of the proxies trusted to set the Forwarded and X-Forwarded-* headers")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"),
+		"Bearer token of the administration routes: export, import, backups and domains. They are closed when empty")
+	ownerTokens := flag.String("owner-tokens", os.Getenv("OWNER_TOKENS"),
+		"Comma separated owner=token pairs, the bearer tokens owners shorten and manage their webhooks with")
+	flag.StringVar(&cfg.logFormat, "log-format", envOr("LOG_FORMAT", "text"), `Format of the logs, "text" or "json"`)
+	flag.StringVar(&cfg.traceExporter, "trace-exporter", envOr("TRACE_EXPORTER", tracing.ExporterNone),
+		`Where the traces are sent, "none", "stdout" or "otlp" (configured with the OTEL_EXPORTER_OTLP_* variables)`)
+	queryTimeout := flag.String("query-timeout", envOr("QUERY_TIMEOUT", "5s"),
+		"How long a database operation may take before it is cancelled, 0 disables the deadline")
+	drainDelay := flag.String("drain-delay", envOr("DRAIN_DELAY", "5s"),
+		"How long readiness fails on shutdown before the server stops accepting requests")
+	shutdownTimeout := flag.String("shutdown-timeout", envOr("SHUTDOWN_TIMEOUT", "15s"),
+		"How long in-flight requests and background fetches get to finish on shutdown")
+	flag.StringVar(&cfg.backupDir, "backup-dir", os.Getenv("BACKUP_DIR"),
+		"Directory of the database backups, the backup endpoints and the schedule are disabled when empty")
+	backupInterval := flag.String("backup-interval", envOr("BACKUP_INTERVAL", "0"),
+		"How often a backup is taken in the backup directory, 0 disables the schedule")
+	backupKeep := flag.String("backup-keep", envOr("BACKUP_KEEP", "7"),
+		"How many backups are kept in the backup directory, 0 keeps them all")
+	flag.Parse()
+
+	if cfg.logFormat != "text" && cfg.logFormat != "json" {
+		return nil, fmt.Errorf("invalid log format %q", cfg.logFormat)
+	}
+
+	if *baseURL != "" {
+		u, err := url.Parse(*baseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid base URL %q", *baseURL)
+		}
+		cfg.baseURL = u
+	}
+
+	cfg.redirectPrefix = strings.TrimSuffix(cfg.redirectPrefix, "/")
+	if err := handler.ValidatePrefix(cfg.redirectPrefix); err != nil {
+		return nil, err
+	}
+
+	switch cfg.traceExporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q", cfg.traceExporter)
+	}
+
+	var err error
+	cfg.pragmas.JournalMode = strings.ToUpper(cfg.pragmas.JournalMode)
+	if !slices.Contains(models.JournalModes, cfg.pragmas.JournalMode) {
+		return nil, fmt.Errorf("invalid journal mode %q", cfg.pragmas.JournalMode)
+	}
+	cfg.pragmas.Synchronous = strings.ToUpper(cfg.pragmas.Synchronous)
+	if !slices.Contains(models.SynchronousModes, cfg.pragmas.Synchronous) {
+		return nil, fmt.Errorf("invalid synchronous mode %q", cfg.pragmas.Synchronous)
+	}
+	if cfg.pragmas.BusyTimeout, err = time.ParseDuration(*busyTimeout); err != nil || cfg.pragmas.BusyTimeout < 0 {
+		return nil, fmt.Errorf("invalid busy timeout %q", *busyTimeout)
+	}
+	if cfg.pragmas.ForeignKeys, err = strconv.ParseBool(*foreignKeys); err != nil {
+		return nil, fmt.Errorf("invalid foreign keys setting %q", *foreignKeys)
+	}
+	if cfg.readConns, err = strconv.Atoi(*readConns); err != nil || cfg.readConns < 1 {
+		return nil, fmt.Errorf("invalid number of read connections %q", *readConns)
+	}
+	if cfg.queryTimeout, err = time.ParseDuration(*queryTimeout); err != nil || cfg.queryTimeout < 0 {
+		return nil, fmt.Errorf("invalid query timeout %q", *queryTimeout)
+	}
+	if cfg.drainDelay, err = time.ParseDuration(*drainDelay); err != nil || cfg.drainDelay < 0 {
+		return nil, fmt.Errorf("invalid drain delay %q", *drainDelay)
+	}
+	if cfg.shutdownTimeout, err = time.ParseDuration(*shutdownTimeout); err != nil || cfg.shutdownTimeout <= 0 {
+		return nil, fmt.Errorf("invalid shutdown timeout %q", *shutdownTimeout)
+	}
+	if cfg.backupInterval, err = time.ParseDuration(*backupInterval); err != nil || cfg.backupInterval < 0 {
+		return nil, fmt.Errorf("invalid backup interval %q", *backupInterval)
+	}
+	if cfg.backupInterval > 0 && cfg.backupDir == "" {
+		return nil, errors.New("scheduled backups need a backup directory")
+	}
+	if cfg.backupKeep, err = strconv.Atoi(*backupKeep); err != nil || cfg.backupKeep < 0 {
+		return nil, fmt.Errorf("invalid number of backups to keep %q", *backupKeep)
+	}
+	if cfg.grpcAddr != "" && cfg.grpcAddr == cfg.addr {
+		return nil, fmt.Errorf("the gRPC address %q is the HTTP address", cfg.grpcAddr)
+	}
+	if cfg.trustedProxies, err = proxy.ParseProxies(*trustedProxies); err != nil {
+		return nil, err
+	}
+	owners, err := auth.ParseOwnerTokens(*ownerTokens)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.tokens, err = auth.NewTokens(*adminToken, owners); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
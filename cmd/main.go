@@ -1,51 +1,209 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"go-url-shortener/internal/api"
+	"go-url-shortener/internal/api/handler"
+	"go-url-shortener/internal/backup"
+	"go-url-shortener/internal/health"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/metrics"
 	"go-url-shortener/internal/models"
-	"log"
+	"go-url-shortener/internal/tracing"
+	"go-url-shortener/internal/webhooks"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"google.golang.org/grpc"
 	_ "modernc.org/sqlite"
 )
 
+// newLogger creates the logger of the service in the configured format
+func newLogger(w io.Writer, format string) *slog.Logger {
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, nil))
+	}
+	return slog.New(slog.NewTextHandler(w, nil))
+}
+
+// openDB opens the SQLite database with the configured pragmas and checks it can be reached
+func openDB(cfg *config) (*models.DB, error) {
+	db, err := models.Open(cfg.dbPath, cfg.pragmas, cfg.readConns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the database: %w", err)
+	}
+	if err = db.Ping(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping the database: %w", err)
+	}
+	return db, nil
+}
+
 func main() {
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	flag.Usage = usage
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
 	}
-	addr := flag.String("addr", ":"+port, "HTTP network address")
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// without a subcommand the binary serves, as it always did
+	name := flag.Arg(0)
+	if name == "" || name == "serve" {
+		serve(cfg)
+		return
+	}
+	os.Exit(runCommand(cfg, name, flag.Args()[1:]))
+}
 
-	dbPath := os.Getenv("DATABASE_PATH")
-	if dbPath == "" {
-		dbPath, _ = filepath.Abs("db/migrations/database.db")
+// stopGRPC lets the in-flight calls finish and cuts them off when ctx is done
+func stopGRPC(ctx context.Context, server *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		server.Stop()
 	}
-	db, err := sql.Open("sqlite", dbPath)
+}
+
+// serve runs the HTTP server, and the gRPC server when it has an address, until it
+// receives SIGINT or SIGTERM
+func serve(cfg *config) {
+	logger := newLogger(os.Stdout, cfg.logFormat)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.traceExporter, os.Stderr)
 	if err != nil {
-		errorLog.Fatalf("Failed to open the database: %v", err)
+		logger.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
 	}
-	if err = db.Ping(); err != nil {
-		errorLog.Fatalf("Failed to ping the database: %v", err)
+
+	db, err := openDB(cfg)
+	if err != nil {
+		logger.Error("Failed to open the database", "error", err)
+		os.Exit(1)
 	}
 	defer db.Close()
+	metrics.RegisterDB(db.Write, "sqlite_write")
+	metrics.RegisterDB(db.Read, "sqlite_read")
 
-	URLShortener := &models.ShortenerDBModel{DB: db}
-	app := api.NewApp(URLShortener)
+	URLShortener := &models.ShortenerDBModel{DB: db.Write, ReadDB: db.Read, QueryTimeout: cfg.queryTimeout}
+	pages := metadata.NewQueue(metadata.NewFetcher(), URLShortener, 2, logger)
+	domains := &models.DomainDBModel{DB: db.Write, ReadDB: db.Read, QueryTimeout: cfg.queryTimeout}
+	links := &handler.LinkBuilder{
+		BaseURL: cfg.baseURL,
+		Prefix:  cfg.redirectPrefix,
+		Proxies: cfg.trustedProxies,
+	}
+	hooks := &models.WebhookDBModel{DB: db.Write, QueryTimeout: cfg.queryTimeout}
+	dispatcher := webhooks.NewDispatcher(hooks, nil, logger, webhooks.Options{})
+	checker := health.New()
+	checker.AddReadiness("database", db.Ping)
+	checker.AddReadiness("schema", models.CheckSchema(db.Read))
+	checker.AddLiveness("metadata_workers", pages.Check)
+	checker.AddLiveness("webhook_dispatcher", dispatcher.Check)
+
+	opts := []api.Option{
+		api.WithLogger(logger),
+		api.WithHealth(checker),
+		api.WithPageMetadata(pages),
+		api.WithDomains(domains),
+		api.WithLinks(links),
+		api.WithWebhooks(hooks, dispatcher),
+		api.WithTokens(cfg.tokens),
+	}
+	var scheduler *backup.Scheduler
+	if cfg.backupDir != "" {
+		// a backup only reads so it doesn't hold up the writes
+		backups := &backup.Dir{DB: db.Read, Path: cfg.backupDir, Keep: cfg.backupKeep}
+		opts = append(opts, api.WithBackups(backups))
+		if cfg.backupInterval > 0 {
+			scheduler = backup.NewScheduler(backups, cfg.backupInterval, logger)
+		}
+	}
+	app := api.NewApp(URLShortener, opts...)
 
 	srv := &http.Server{
-		Addr:     *addr,
-		ErrorLog: errorLog,
+		Addr:     cfg.addr,
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:  app.Routes(),
 	}
 
-	infoLog.Printf("Starting server on %s", *addr)
-	err = srv.ListenAndServe()
-	errorLog.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("Starting server", "addr", cfg.addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// the gRPC API runs next to the HTTP server on a port of its own
+	grpcErr := make(chan error, 1)
+	grpcServer := app.GRPCServer(cfg.host())
+	if cfg.grpcAddr != "" {
+		lis, err := net.Listen("tcp", cfg.grpcAddr)
+		if err != nil {
+			logger.Error("Failed to listen for gRPC", "error", err)
+			os.Exit(1)
+		}
+		go func() {
+			logger.Info("Starting gRPC server", "addr", cfg.grpcAddr)
+			grpcErr <- grpcServer.Serve(lis)
+		}()
+	}
+
+	select {
+	case err = <-serveErr:
+		logger.Error("Server stopped", "error", err)
+		os.Exit(1)
+	case err = <-grpcErr:
+		logger.Error("gRPC server stopped", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+		stop()
+	}
+
+	// fail the readiness first so load balancers stop sending requests before we stop accepting them
+	logger.Info("Shutting down, draining", "delay", cfg.drainDelay)
+	checker.Drain()
+	time.Sleep(cfg.drainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.shutdownTimeout)
+	defer cancel()
+	if err = srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to shut down the server", "error", err)
+	}
+	if err = <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Server stopped", "error", err)
+	}
+	stopGRPC(shutdownCtx, grpcServer)
+	if err = pages.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to finish the metadata fetches", "error", err)
+	}
+	if err = dispatcher.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to stop the webhook dispatcher", "error", err)
+	}
+	if scheduler != nil {
+		if err = scheduler.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Failed to stop the backup schedule", "error", err)
+		}
+	}
+	if err = shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush the traces", "error", err)
+	}
+	logger.Info("Server stopped")
 }
@@ -0,0 +1,21 @@
+-- migrate:up
+-- Add a human readable title and free-form notes to every url
+ALTER TABLE urls ADD COLUMN title TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN notes TEXT NOT NULL DEFAULT '';
+
+-- Create a tags table, tag names are stored normalised (lower case) and are unique
+CREATE TABLE IF NOT EXISTS "tags" (
+    tag_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE NOT NULL
+);
+
+-- Create a join table so a url can have many tags and a tag can be used by many urls
+CREATE TABLE IF NOT EXISTS "url_tags" (
+    url_id INTEGER NOT NULL REFERENCES urls (url_id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags (tag_id) ON DELETE CASCADE,
+    PRIMARY KEY (url_id, tag_id)
+);
+
+-- Listing by tag looks up the urls of a tag
+CREATE INDEX idx_url_tags_tag ON url_tags (tag_id);
+-- migrate:down
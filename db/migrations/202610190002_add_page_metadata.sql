@@ -0,0 +1,9 @@
+-- migrate:up
+-- Store the metadata of the destination page, it is fetched in the background after a url is shortened
+ALTER TABLE urls ADD COLUMN page_title TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN page_description TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN page_image_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE urls ADD COLUMN page_favicon_url TEXT NOT NULL DEFAULT '';
+-- NULL until the page has been fetched successfully
+ALTER TABLE urls ADD COLUMN page_fetched DATETIME;
+-- migrate:down
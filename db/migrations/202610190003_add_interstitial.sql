@@ -0,0 +1,4 @@
+-- migrate:up
+-- Links with interstitial set show a "you are leaving" page before redirecting
+ALTER TABLE urls ADD COLUMN interstitial BOOLEAN NOT NULL DEFAULT FALSE;
+-- migrate:down
@@ -0,0 +1,62 @@
+-- migrate:up
+-- Create a domains table so one deployment can serve several branded short domains
+CREATE TABLE IF NOT EXISTS "domains" (
+    domain_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    host TEXT UNIQUE NOT NULL,
+    -- where the bare root of the domain redirects to, empty means no redirect
+    root_redirect TEXT NOT NULL DEFAULT '',
+    created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The owners allowed to shorten urls on a domain, a domain without owners is open to everyone
+CREATE TABLE IF NOT EXISTS "domain_owners" (
+    domain_id INTEGER NOT NULL REFERENCES domains (domain_id) ON DELETE CASCADE,
+    owner TEXT NOT NULL,
+    PRIMARY KEY (domain_id, owner)
+);
+
+-- Every domain has its own key namespace, so the unique constraints of the urls table
+-- become unique per domain. SQLite can't change constraints in place so the table is rebuilt.
+-- The domain column holds the host of the domain, urls outside of any domain use ''.
+CREATE TABLE "urls_new" (
+    url_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    domain TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL DEFAULT '',
+    original_url TEXT NOT NULL,
+    shortened_url_key TEXT NOT NULL,
+    clicks INTEGER DEFAULT 0,
+    active BOOLEAN DEFAULT TRUE,
+    created DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated DATETIME DEFAULT CURRENT_TIMESTAMP,
+    title TEXT NOT NULL DEFAULT '',
+    notes TEXT NOT NULL DEFAULT '',
+    page_title TEXT NOT NULL DEFAULT '',
+    page_description TEXT NOT NULL DEFAULT '',
+    page_image_url TEXT NOT NULL DEFAULT '',
+    page_favicon_url TEXT NOT NULL DEFAULT '',
+    page_fetched DATETIME,
+    interstitial BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (domain, original_url),
+    UNIQUE (domain, shortened_url_key)
+);
+
+INSERT INTO urls_new (url_id, original_url, shortened_url_key, clicks, active, created, updated, title, notes,
+    page_title, page_description, page_image_url, page_favicon_url, page_fetched, interstitial)
+SELECT url_id, original_url, shortened_url_key, clicks, active, created, updated, title, notes,
+    page_title, page_description, page_image_url, page_favicon_url, page_fetched, interstitial
+FROM urls;
+
+DROP TABLE urls;
+ALTER TABLE urls_new RENAME TO urls;
+
+-- The unique constraints already index the lookups by domain and key or original url,
+-- recreate the trigger dropped with the old table
+CREATE INDEX idx_owner ON urls (owner);
+
+CREATE TRIGGER update_timestamp
+AFTER UPDATE ON urls
+FOR EACH ROW
+BEGIN
+    UPDATE urls SET updated = CURRENT_TIMESTAMP WHERE url_id = OLD.url_id;
+END;
+-- migrate:down
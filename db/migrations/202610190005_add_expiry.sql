@@ -0,0 +1,4 @@
+-- migrate:up
+-- Links stop redirecting after expires_at, NULL means the link never expires
+ALTER TABLE urls ADD COLUMN expires_at DATETIME;
+-- migrate:down
@@ -0,0 +1,5 @@
+-- migrate:up
+-- Record the schema version so the readiness check can tell when migrations are pending,
+-- every new migration has to set it to the next number and bump models.SchemaVersion
+PRAGMA user_version = 6;
+-- migrate:down
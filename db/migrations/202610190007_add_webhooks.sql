@@ -0,0 +1,34 @@
+-- migrate:up
+-- Webhook endpoints registered by owners, events is a comma separated list and empty means every event
+CREATE TABLE IF NOT EXISTS "webhooks" (
+    webhook_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    owner TEXT NOT NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '',
+    click_threshold INTEGER NOT NULL DEFAULT 0,
+    created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhooks_owner ON webhooks (owner);
+
+-- The outbox keeps every delivery until it succeeds or runs out of attempts so deliveries survive restarts
+CREATE TABLE IF NOT EXISTS "webhook_outbox" (
+    delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL REFERENCES webhooks (webhook_id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt DATETIME,
+    last_error TEXT NOT NULL DEFAULT '',
+    delivered DATETIME,
+    created DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_webhook_outbox_pending ON webhook_outbox (next_attempt) WHERE delivered IS NULL;
+
+-- Expired links are announced once
+ALTER TABLE urls ADD COLUMN expiry_notified BOOLEAN NOT NULL DEFAULT FALSE;
+
+PRAGMA user_version = 7;
+-- migrate:down
@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations of the database so the binary can apply them
+package migrations
+
+import "embed"
+
+// FS holds the migrations, they run in the order of their names
+//
+//go:embed *.sql
+var FS embed.FS
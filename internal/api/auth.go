@@ -0,0 +1,57 @@
+package api
+
+import (
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/utils"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// authenticate identifies the caller by the bearer token of the Authorization header,
+// a request without one is anonymous and a request with an unknown token is rejected
+func (app *App) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, ok := auth.BearerToken(header)
+		principal, known := app.tokens.Authenticate(token)
+		if !ok || !known {
+			unauthorized(w, "Invalid credentials")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+// requireAdmin only lets the administrator call h
+func requireAdmin(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if principal, ok := auth.FromContext(r.Context()); !ok || !principal.Admin {
+			unauthorized(w, "This route needs the admin token")
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// requireAuth only lets an authenticated caller, an owner or the administrator, call h
+func requireAuth(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if _, ok := auth.FromContext(r.Context()); !ok {
+			unauthorized(w, "This route needs an owner token or the admin token")
+			return
+		}
+		h(w, r, ps)
+	}
+}
+
+// unauthorized asks the client for a bearer token
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="go-url-shortener"`)
+	utils.SendError(w, http.StatusUnauthorized, h.CodeUnauthorized, message)
+}
@@ -0,0 +1,134 @@
+// Package graphql serves the links and their stats over GraphQL for the dashboards,
+// on top of the same storage and checks as the REST API
+package graphql
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"go-url-shortener/internal/api/handler"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/webhooks"
+	"net/http"
+
+	gql "github.com/graph-gophers/graphql-go"
+	"github.com/julienschmidt/httprouter"
+)
+
+//go:embed schema.graphql
+var Schema string
+
+// MaxDepth bounds how deeply a query can nest its selections
+const MaxDepth = 10
+
+// Request is the body of a GraphQL request
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type contextKey string
+
+const requestContextKey = contextKey("request")
+
+// request returns the HTTP request a resolver runs for, the short URLs are built from it
+func request(ctx context.Context) *http.Request {
+	return ctx.Value(requestContextKey).(*http.Request)
+}
+
+// Handler executes the GraphQL requests posted as JSON. The links are looked up on the
+// domain of the request unless a query asks for another domain, and the webhooks of
+// the owners are told about the changes made by the mutations when hooks is set
+func Handler(sd models.ShortenerDataInterface, domains models.DomainInterface, pages *metadata.Queue, links *handler.LinkBuilder,
+	hooks *webhooks.Dispatcher) httprouter.Handle {
+	root := &resolver{urls: sd, domains: domains, pages: pages, links: links, hooks: hooks}
+	schema := gql.MustParseSchema(Schema, root, gql.MaxDepth(MaxDepth))
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req Request
+		if err := handler.DecodeJSON(w, r, &req); err != nil {
+			handler.SendBodyError(w, err)
+			return
+		}
+		if req.Query == "" {
+			utils.SendError(w, http.StatusBadRequest, h.CodeValidationFailed, "Missing query in the request payload",
+				h.FieldError{Field: "query", Code: "missing_query", Message: "Missing query in the request payload"})
+			return
+		}
+
+		// every request batches the stats of its links in a loader of its own
+		ctx := context.WithValue(r.Context(), requestContextKey, r)
+		ctx = withStatsLoader(ctx, sd)
+		response := schema.Exec(ctx, req.Query, req.OperationName, req.Variables)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// Error is a failed field of a query, its code is one of the codes of the REST API
+// error envelope and field names the invalid input field when there is one
+type Error struct {
+	Code    string
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Extensions adds the code and field to the error in the response
+func (e *Error) Extensions() map[string]any {
+	extensions := map[string]any{"code": e.Code}
+	if e.Field != "" {
+		extensions["field"] = e.Field
+	}
+	return extensions
+}
+
+// invalidField reports a field rejected by the checks of the handlers
+func invalidField(invalid *h.FieldError) *Error {
+	return &Error{Code: invalid.Code, Field: invalid.Field, Message: invalid.Message}
+}
+
+// authorizeError reports a caller that may not change a link
+func authorizeError(err error) *Error {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return &Error{Code: h.CodeUnauthorized, Message: "Changing a link needs the token of its owner or the admin token"}
+	case errors.Is(err, auth.ErrForbidden):
+		return &Error{Code: h.CodeForbidden, Message: "The link belongs to another owner"}
+	default:
+		return storageError(err, "Unable to look up the link")
+	}
+}
+
+// listOwnerError reports a caller that may not list the links of an owner
+func listOwnerError(err error) *Error {
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return &Error{Code: h.CodeUnauthorized, Message: "Listing the links of an owner needs its token or the admin token"}
+	}
+	return &Error{Code: h.CodeForbidden, Message: "The links of another owner can't be listed"}
+}
+
+// storageError reports a failed storage call, message is shown unless the error is temporary
+func storageError(err error, message string) *Error {
+	if errors.Is(err, models.ErrNotFound) {
+		return &Error{Code: h.CodeLinkNotFound, Message: "Shortened URL not found"}
+	}
+	switch code := handler.StorageErrorCode(err); code {
+	case h.CodeTimeout:
+		return &Error{Code: code, Message: "The database did not answer in time"}
+	case h.CodeKeyExhausted:
+		return &Error{Code: code, Message: "Unable to generate an unused key, please retry"}
+	default:
+		return &Error{Code: code, Message: message}
+	}
+}
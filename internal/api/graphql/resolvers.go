@@ -0,0 +1,289 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"go-url-shortener/internal/api/handler"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/webhooks"
+	"strings"
+	"time"
+
+	gql "github.com/graph-gophers/graphql-go"
+)
+
+// resolver resolves the queries and mutations of the schema
+type resolver struct {
+	urls    models.ShortenerDataInterface
+	domains models.DomainInterface
+	pages   *metadata.Queue
+	links   *handler.LinkBuilder
+	hooks   *webhooks.Dispatcher
+}
+
+// targetDomain is the domain argument of a field, or else the domain of the request
+func targetDomain(ctx context.Context, domain *string) string {
+	if value(domain) != "" {
+		return utils.NormalizeHost(*domain)
+	}
+	return handler.DomainFromContext(ctx).Host
+}
+
+// checkKey rejects a key that can't be a short link
+func checkKey(key string) error {
+	if !utils.IsValidURLKey(key) {
+		return &Error{Code: h.CodeInvalidKey, Field: "key", Message: "Shortened URL is invalid"}
+	}
+	return nil
+}
+
+func (r *resolver) link(data *models.ShortenerData) *linkResolver {
+	return &linkResolver{data: data, links: r.links}
+}
+
+func (r *resolver) Link(ctx context.Context, args struct {
+	Key    string
+	Domain *string
+}) (*linkResolver, error) {
+	if err := checkKey(args.Key); err != nil {
+		return nil, err
+	}
+	data, err := r.urls.Get(ctx, targetDomain(ctx, args.Domain), args.Key)
+	if errors.Is(err, models.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storageError(err, "Unable to look up the link")
+	}
+	return r.link(data), nil
+}
+
+func (r *resolver) Links(ctx context.Context, args struct {
+	Domain *string
+	Owner  *string
+	Tag    *string
+	Search *string
+	Limit  *int32
+	Offset *int32
+}) ([]*linkResolver, error) {
+	filter := &models.ListFilter{
+		Domain: targetDomain(ctx, args.Domain),
+		Owner:  strings.TrimSpace(value(args.Owner)),
+		Tag:    utils.NormalizeTag(value(args.Tag)),
+		Search: strings.TrimSpace(value(args.Search)),
+	}
+	if filter.Owner != "" {
+		if err := handler.AuthorizeOwner(ctx, filter.Owner); err != nil {
+			return nil, listOwnerError(err)
+		}
+	}
+	if args.Limit != nil {
+		if *args.Limit < 0 {
+			return nil, &Error{Code: h.CodeValidationFailed, Field: "limit", Message: "Invalid limit"}
+		}
+		filter.Limit = min(int(*args.Limit), handler.MaxListLimit)
+	}
+	if args.Offset != nil {
+		if *args.Offset < 0 {
+			return nil, &Error{Code: h.CodeValidationFailed, Field: "offset", Message: "Invalid offset"}
+		}
+		filter.Offset = int(*args.Offset)
+	}
+
+	list, err := r.urls.List(ctx, filter)
+	if err != nil {
+		return nil, storageError(err, "Unable to list the links")
+	}
+	links := make([]*linkResolver, 0, len(list))
+	for _, data := range list {
+		links = append(links, r.link(data))
+	}
+	return links, nil
+}
+
+func (r *resolver) Stats(ctx context.Context, args struct {
+	Domain *string
+	Owner  *string
+}) (*statsResolver, error) {
+	return loadStats(ctx, models.StatsKey{Domain: targetDomain(ctx, args.Domain), Owner: strings.TrimSpace(value(args.Owner))})
+}
+
+type createLinkInput struct {
+	URL          string
+	Domain       *string
+	Owner        *string
+	Title        *string
+	Notes        *string
+	Tags         *[]string
+	Interstitial *bool
+	ExpiresAt    *gql.Time
+}
+
+type createLinkPayload struct {
+	link    *linkResolver
+	message string
+}
+
+func (p *createLinkPayload) Link() *linkResolver { return p.link }
+
+func (p *createLinkPayload) Message() string { return p.message }
+
+func (r *resolver) CreateLink(ctx context.Context, args struct{ Input createLinkInput }) (*createLinkPayload, error) {
+	in := args.Input
+	req := &h.URLRequest{
+		URL:          in.URL,
+		Domain:       value(in.Domain),
+		Owner:        value(in.Owner),
+		Title:        value(in.Title),
+		Notes:        value(in.Notes),
+		Tags:         value(in.Tags),
+		Interstitial: value(in.Interstitial),
+	}
+	if in.ExpiresAt != nil {
+		req.ExpiresAt = &in.ExpiresAt.Time
+	}
+
+	link, msg, err := handler.Shorten(ctx, r.urls, r.domains, r.pages, r.hooks, req)
+	if err != nil {
+		var invalid *h.FieldError
+		if errors.As(err, &invalid) {
+			return nil, invalidField(invalid)
+		}
+		return nil, storageError(err, "Unable to shorten the URL")
+	}
+	// the URL may have been shortened before, the stored link is returned as it is
+	data, err := r.urls.Get(ctx, link.Domain, link.ShortenedURLKEY)
+	if err != nil {
+		return nil, storageError(err, "Unable to look up the link")
+	}
+	return &createLinkPayload{link: r.link(data), message: msg}, nil
+}
+
+type updateLinkInput struct {
+	Title        *string
+	Notes        *string
+	Tags         *[]string
+	Interstitial *bool
+	ExpiresAt    *string
+}
+
+func (r *resolver) UpdateLink(ctx context.Context, args struct {
+	Key    string
+	Domain *string
+	Input  updateLinkInput
+}) (*linkResolver, error) {
+	if err := checkKey(args.Key); err != nil {
+		return nil, err
+	}
+	domain := targetDomain(ctx, args.Domain)
+	if err := handler.AuthorizeLink(ctx, r.urls, domain, args.Key); err != nil {
+		return nil, authorizeError(err)
+	}
+	in := args.Input
+	update, invalid := handler.LinkUpdate(&h.LinkUpdateRequest{
+		Title:        in.Title,
+		Notes:        in.Notes,
+		Tags:         in.Tags,
+		Interstitial: in.Interstitial,
+		ExpiresAt:    in.ExpiresAt,
+	})
+	if invalid != nil {
+		return nil, invalidField(invalid)
+	}
+
+	data, err := r.urls.Update(ctx, domain, args.Key, update)
+	if err != nil {
+		return nil, storageError(err, "Unable to update the link")
+	}
+	if r.hooks != nil {
+		r.hooks.Notify(ctx, models.EventLinkUpdated, data)
+	}
+	return r.link(data), nil
+}
+
+func (r *resolver) DeactivateLink(ctx context.Context, args struct {
+	Key    string
+	Domain *string
+}) (*linkResolver, error) {
+	if err := checkKey(args.Key); err != nil {
+		return nil, err
+	}
+	domain := targetDomain(ctx, args.Domain)
+	if err := handler.AuthorizeLink(ctx, r.urls, domain, args.Key); err != nil {
+		return nil, authorizeError(err)
+	}
+	data, err := r.urls.Deactivate(ctx, domain, args.Key)
+	if err != nil {
+		return nil, storageError(err, "Unable to deactivate the link")
+	}
+	if r.hooks != nil {
+		r.hooks.Notify(ctx, models.EventLinkDeactivated, data)
+	}
+	return r.link(data), nil
+}
+
+// linkResolver resolves the fields of a link
+type linkResolver struct {
+	data  *models.ShortenerData
+	links *handler.LinkBuilder
+}
+
+func (l *linkResolver) Domain() string { return l.data.Domain }
+
+func (l *linkResolver) Owner() string { return l.data.Owner }
+
+func (l *linkResolver) Key() string { return l.data.ShortenedURLKEY }
+
+func (l *linkResolver) ShortURL(ctx context.Context) string {
+	return l.links.ShortURL(request(ctx), l.data.Domain, l.data.ShortenedURLKEY)
+}
+
+func (l *linkResolver) OriginalURL() string { return l.data.OriginalURL }
+
+func (l *linkResolver) Title() string { return l.data.Title }
+
+func (l *linkResolver) Notes() string { return l.data.Notes }
+
+func (l *linkResolver) Tags() []string {
+	if l.data.Tags == nil {
+		return []string{}
+	}
+	return l.data.Tags
+}
+
+func (l *linkResolver) Interstitial() bool { return l.data.Interstitial }
+
+func (l *linkResolver) ExpiresAt() *gql.Time { return optionalTime(l.data.ExpiresAt) }
+
+func (l *linkResolver) Active() bool { return !l.data.Deactivated }
+
+func (l *linkResolver) Expired() bool { return l.data.IsExpired(time.Now()) }
+
+func (l *linkResolver) Clicks() int32 { return int32(l.data.Clicks) }
+
+func (l *linkResolver) Created() *gql.Time { return optionalTime(l.data.Created) }
+
+// OwnerStats are loaded in one batch with the stats of the other links of the response
+func (l *linkResolver) OwnerStats(ctx context.Context) (*statsResolver, error) {
+	return loadStats(ctx, models.StatsKey{Domain: l.data.Domain, Owner: l.data.Owner})
+}
+
+// value is the value of an optional argument, the zero value when it is not set
+func value[T any](arg *T) T {
+	if arg == nil {
+		var zero T
+		return zero
+	}
+	return *arg
+}
+
+// optionalTime is null for a zero time
+func optionalTime(t time.Time) *gql.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &gql.Time{Time: t}
+}
@@ -0,0 +1,64 @@
+package graphql
+
+import (
+	"context"
+	"go-url-shortener/internal/models"
+	"time"
+
+	"github.com/graph-gophers/dataloader/v7"
+)
+
+// statsWait is how long the loader gathers the stats asked by the resolvers before
+// looking them up in one batch
+const statsWait = 2 * time.Millisecond
+
+type statsLoader = dataloader.Loader[models.StatsKey, *models.LinkStats]
+
+const statsContextKey = contextKey("stats")
+
+// withStatsLoader stores a loader of stats in ctx, it lives as long as the request so
+// the stats asked several times are only looked up once
+func withStatsLoader(ctx context.Context, sd models.ShortenerDataInterface) context.Context {
+	batch := func(ctx context.Context, keys []models.StatsKey) []*dataloader.Result[*models.LinkStats] {
+		results := make([]*dataloader.Result[*models.LinkStats], len(keys))
+		stats, err := sd.Stats(ctx, keys, time.Now())
+		for i, key := range keys {
+			if err != nil {
+				results[i] = &dataloader.Result[*models.LinkStats]{Error: storageError(err, "Unable to count the links")}
+				continue
+			}
+			results[i] = &dataloader.Result[*models.LinkStats]{Data: stats[key]}
+		}
+		return results
+	}
+	loader := dataloader.NewBatchedLoader(batch, dataloader.WithWait[models.StatsKey, *models.LinkStats](statsWait))
+	return context.WithValue(ctx, statsContextKey, loader)
+}
+
+// loadStats returns the stats of key, batched with the stats asked by the other resolvers of the request
+func loadStats(ctx context.Context, key models.StatsKey) (*statsResolver, error) {
+	stats, err := ctx.Value(statsContextKey).(*statsLoader).Load(ctx, key)()
+	if err != nil {
+		return nil, err
+	}
+	return &statsResolver{key: key, stats: stats}, nil
+}
+
+type statsResolver struct {
+	key   models.StatsKey
+	stats *models.LinkStats
+}
+
+func (s *statsResolver) Domain() string { return s.key.Domain }
+
+func (s *statsResolver) Owner() string { return s.key.Owner }
+
+func (s *statsResolver) Links() int32 { return int32(s.stats.Links) }
+
+func (s *statsResolver) Active() int32 { return int32(s.stats.Active) }
+
+func (s *statsResolver) Expired() int32 { return int32(s.stats.Expired) }
+
+func (s *statsResolver) Deactivated() int32 { return int32(s.stats.Deactivated) }
+
+func (s *statsResolver) Clicks() int32 { return int32(s.stats.Clicks) }
@@ -0,0 +1,22 @@
+package api
+
+import (
+	"go-url-shortener/internal/api/rpc"
+
+	"google.golang.org/grpc"
+)
+
+// GRPCServer creates the gRPC server of the app, it shares the storage, domains and
+// webhooks of the HTTP routes. host is the host of the short URLs of the default
+// domain when the links have no base URL
+func (app *App) GRPCServer(host string) *grpc.Server {
+	svc := &rpc.Service{
+		URLs:    app.urls,
+		Domains: app.domains,
+		Pages:   app.pages,
+		Links:   app.links,
+		Hooks:   app.dispatcher,
+		Host:    host,
+	}
+	return rpc.NewServer(svc, app.tokens, app.logger)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/backup"
+	"go-url-shortener/internal/utils"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CreateBackup takes a consistent backup of the live database in the backup directory,
+// the oldest backups beyond the retention are removed. Backups are taken one at a time
+func CreateBackup(backups *backup.Dir) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		info, err := backups.Create(r.Context())
+		if errors.Is(err, backup.ErrInProgress) {
+			utils.SendError(w, http.StatusConflict, h.CodeConflict, "A backup is already in progress")
+			return
+		}
+		if info == nil {
+			sendStorageError(w, err, "Unable to back up the database")
+			return
+		}
+		// the backup was taken even when removing the old ones failed, they go with the next one
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(backupResponse(info))
+	}
+}
+
+// ListBackups lists the backups of the backup directory, newest first
+func ListBackups(backups *backup.Dir) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		list, err := backups.List()
+		if err != nil {
+			sendStorageError(w, err, "Unable to list the backups")
+			return
+		}
+		response := h.BackupsResponse{Backups: make([]h.BackupResponse, 0, len(list))}
+		for _, info := range list {
+			response.Backups = append(response.Backups, backupResponse(info))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// backupResponse describes a backup by its name, the path of the directory stays on the server
+func backupResponse(info *backup.Info) h.BackupResponse {
+	return h.BackupResponse{Name: info.Name, Size: info.Size, CreatedAt: info.Created}
+}
@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/utils"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxBodySize caps the body of the requests of the API, imports have their own limit
+const MaxBodySize = 64 << 10
+
+// Media types the shorten endpoint reads and answers
+const (
+	mediaJSON = "application/json"
+	mediaForm = "application/x-www-form-urlencoded"
+	mediaText = "text/plain"
+)
+
+// errUnsupportedMediaType is returned for a body that is neither JSON, a form nor plain text
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// DecodeJSON decodes the JSON body of a request of at most MaxBodySize bytes
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodySize)).Decode(v)
+}
+
+// SendBodyError answers a request whose JSON body couldn't be decoded
+func SendBodyError(w http.ResponseWriter, err error) {
+	if isTooLarge(err) {
+		sendTooLarge(w)
+		return
+	}
+	sendInvalidJSON(w)
+}
+
+func isTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+func sendTooLarge(w http.ResponseWriter) {
+	utils.SendError(w, http.StatusRequestEntityTooLarge, h.CodePayloadTooLarge,
+		fmt.Sprintf("Request body exceeds the maximum size of %d bytes", MaxBodySize))
+}
+
+// decodeURLRequest reads a shorten request sent as JSON, as an HTML form or as
+// plain text holding only the URL. A body without a content type is JSON, and so
+// is a form body starting with a brace since that's what curl -d sends for JSON
+func decodeURLRequest(w http.ResponseWriter, r *http.Request) (*h.URLRequest, error) {
+	var mediaType string
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			return nil, errUnsupportedMediaType
+		}
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	var req h.URLRequest
+	switch {
+	case mediaType == "" || mediaType == mediaJSON || (mediaType == mediaForm && strings.HasPrefix(string(body), "{")):
+		err = json.Unmarshal(body, &req)
+	case mediaType == mediaForm:
+		var form url.Values
+		if form, err = url.ParseQuery(string(body)); err == nil {
+			err = formURLRequest(form, &req)
+		}
+	case mediaType == mediaText:
+		req.URL = strings.TrimSpace(string(body))
+	default:
+		err = errUnsupportedMediaType
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// formURLRequest fills a shorten request from the fields of a form, named as in
+// JSON. The tags are repeated fields or separated by commas, and a checked checkbox
+// sends "on"
+func formURLRequest(form url.Values, req *h.URLRequest) error {
+	req.URL = form.Get("url")
+	req.Domain = form.Get("domain")
+	req.Owner = form.Get("owner")
+	req.Title = form.Get("title")
+	req.Notes = form.Get("notes")
+	for _, value := range form["tags"] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				req.Tags = append(req.Tags, tag)
+			}
+		}
+	}
+
+	var err error
+	if req.Interstitial, err = formBool(form, "interstitial"); err != nil {
+		return err
+	}
+	if req.QR, err = formBool(form, "qr"); err != nil {
+		return err
+	}
+	if value := form.Get("expires_at"); value != "" {
+		expiresAt, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fieldError("expires_at", "invalid_expiry", "Expiry must be an RFC 3339 time")
+		}
+		req.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+func formBool(form url.Values, field string) (bool, error) {
+	value := form.Get(field)
+	if value == "" {
+		return false, nil
+	}
+	if value == "on" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fieldError(field, "invalid_boolean", fmt.Sprintf("Invalid %s, must be true or false", field))
+	}
+	return b, nil
+}
+
+// prefersText reports whether the Accept header of the request ranks plain text
+// above JSON, JSON is answered otherwise
+func prefersText(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return false
+	}
+	return acceptQuality(accept, mediaText) > acceptQuality(accept, mediaJSON)
+}
+
+// acceptQuality returns the quality the Accept header gives to the media type,
+// taken from the most specific range that matches it
+func acceptQuality(accept, mediaType string) float64 {
+	typ, subtype, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaRange, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		rangeType, rangeSubtype, _ := strings.Cut(mediaRange, "/")
+		var s int
+		switch {
+		case rangeType == typ && rangeSubtype == subtype:
+			s = 2
+		case rangeType == typ && rangeSubtype == "*":
+			s = 1
+		case rangeType == "*" && rangeSubtype == "*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		quality, specificity = q, s
+	}
+	return quality
+}
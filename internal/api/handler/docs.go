@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"go-url-shortener/internal/api/openapi"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// OpenAPI serves the OpenAPI document of the API
+func OpenAPI() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openapi.Spec)
+	}
+}
+
+// Docs serves the Swagger UI under the filepath parameter, it browses the document served by OpenAPI
+func Docs() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		name := strings.TrimPrefix(ps.ByName("filepath"), "/")
+		switch name {
+		case "":
+			name = "index.html"
+		case "swagger-initializer.js":
+			w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+			w.Write(openapi.Initializer)
+			return
+		}
+		http.ServeFileFS(w, r, openapi.UI, name)
+	}
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/web"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type contextKey string
+
+const domainContextKey = contextKey("domain")
+
+// defaultDomain is used for requests on hosts that aren't a registered domain
+var defaultDomain = &models.Domain{Host: models.DefaultDomain}
+
+// ContextWithDomain stores the registered domain a request was made on
+func ContextWithDomain(ctx context.Context, domain *models.Domain) context.Context {
+	return context.WithValue(ctx, domainContextKey, domain)
+}
+
+// DomainFromContext returns the domain stored by ContextWithDomain, the default
+// domain when the request wasn't made on a registered domain
+func DomainFromContext(ctx context.Context) *models.Domain {
+	if domain, ok := ctx.Value(domainContextKey).(*models.Domain); ok {
+		return domain
+	}
+	return defaultDomain
+}
+
+// requestDomain returns the domain the request was made on, the default domain
+// when the Host header isn't a registered domain
+func requestDomain(r *http.Request) *models.Domain {
+	return DomainFromContext(r.Context())
+}
+
+// targetDomain returns the key namespace a management request works on,
+// the domain query parameter or else the domain of the request
+func targetDomain(r *http.Request) string {
+	if domain := r.URL.Query().Get("domain"); domain != "" {
+		return utils.NormalizeHost(domain)
+	}
+	return requestDomain(r).Host
+}
+
+// lookupDomain finds a registered domain by host
+func lookupDomain(ctx context.Context, domains models.DomainInterface, host string) (*models.Domain, error) {
+	if domains == nil {
+		return nil, models.ErrNotFound
+	}
+	return domains.GetDomain(ctx, utils.NormalizeHost(host))
+}
+
+// DomainRoot redirects the bare root of a domain to its configured destination, the
+// roots without one show the home page to shorten links from a browser
+func DomainRoot() httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		domain := requestDomain(r)
+		if domain.RootRedirect == "" {
+			home := web.HomeData{MaxURLLength: MaxURLLength, MaxTitleLength: MaxTitleLength, MaxOwnerLength: utils.MaxOwnerLength,
+				RecentLinks: RecentLinks}
+			if err := web.Render(w, http.StatusOK, web.HomePage, home); err != nil {
+				utils.SendErrorResponse(w, "Unable to render the page", http.StatusInternalServerError)
+			}
+			return
+		}
+		http.Redirect(w, r, domain.RootRedirect, http.StatusFound)
+	}
+}
+
+// RecentLinks is how many of the links shortened in the browser the home page lists
+const RecentLinks = 20
+
+// ListDomains lists the registered domains
+func ListDomains(domains models.DomainInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		list, err := domains.ListDomains(r.Context())
+		if err != nil {
+			sendStorageError(w, err, "Unable to list the domains")
+			return
+		}
+
+		response := h.DomainsResponse{Domains: make([]h.DomainResponse, 0, len(list))}
+		for _, domain := range list {
+			response.Domains = append(response.Domains, domainResponse(domain))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// SaveDomain registers a domain or replaces its root redirect and owners
+func SaveDomain(domains models.DomainInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		host := utils.NormalizeHost(ps.ByName("host"))
+		if !utils.IsValidHost(host) {
+			sendFieldError(w, http.StatusBadRequest, fieldError("host", "invalid_host", "Invalid host"))
+			return
+		}
+
+		var req h.DomainRequest
+		err := DecodeJSON(w, r, &req)
+		if err != nil {
+			SendBodyError(w, err)
+			return
+		}
+		if req.RootRedirect != "" && !utils.IsValidURL(req.RootRedirect) {
+			sendFieldError(w, http.StatusBadRequest, fieldError("root_redirect", "invalid_url", "Invalid root redirect URL"))
+			return
+		}
+		owners := make([]string, 0, len(req.Owners))
+		for _, owner := range req.Owners {
+			if !utils.IsValidOwner(owner) {
+				sendFieldError(w, http.StatusBadRequest, fieldError("owners", "invalid_owner", "Invalid owner"))
+				return
+			}
+			owners = append(owners, owner)
+		}
+
+		domain := &models.Domain{Host: host, RootRedirect: req.RootRedirect, Owners: owners}
+		if err = domains.SaveDomain(r.Context(), domain); err != nil {
+			sendStorageError(w, err, "Unable to save the domain")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(domainResponse(domain))
+	}
+}
+
+// DeleteDomain removes a domain that has no links
+func DeleteDomain(domains models.DomainInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		err := domains.DeleteDomain(r.Context(), utils.NormalizeHost(ps.ByName("host")))
+		if err != nil {
+			switch {
+			case errors.Is(err, models.ErrNotFound):
+				utils.SendErrorResponse(w, "Domain not found", http.StatusNotFound)
+			case errors.Is(err, models.ErrDomainInUse):
+				utils.SendErrorResponse(w, "Domain still has links", http.StatusConflict)
+			default:
+				sendStorageError(w, err, "Unable to delete the domain")
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func domainResponse(domain *models.Domain) h.DomainResponse {
+	owners := domain.Owners
+	if owners == nil {
+		owners = []string{}
+	}
+	return h.DomainResponse{
+		Host:         domain.Host,
+		RootRedirect: domain.RootRedirect,
+		Owners:       owners,
+	}
+}
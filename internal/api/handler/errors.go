@@ -0,0 +1,32 @@
+package handler
+
+import (
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/utils"
+	"net/http"
+)
+
+// fieldError describes an invalid field of a request, code tells what is wrong with it
+func fieldError(field, code, message string) *h.FieldError {
+	return &h.FieldError{Field: field, Code: code, Message: message}
+}
+
+// sendFieldError rejects a request because of an invalid field
+func sendFieldError(w http.ResponseWriter, status int, fe *h.FieldError) {
+	utils.SendError(w, status, h.CodeValidationFailed, fe.Message, *fe)
+}
+
+// sendInvalidJSON rejects a request whose body isn't the expected JSON
+func sendInvalidJSON(w http.ResponseWriter) {
+	utils.SendError(w, http.StatusBadRequest, h.CodeInvalidJSON, "Invalid JSON payload")
+}
+
+// sendInvalidKey rejects a request for a key that can't be a short link
+func sendInvalidKey(w http.ResponseWriter) {
+	utils.SendError(w, http.StatusBadRequest, h.CodeInvalidKey, "Shortened URL is invalid")
+}
+
+// sendLinkNotFound answers a request for a link that doesn't exist on the domain
+func sendLinkNotFound(w http.ResponseWriter) {
+	utils.SendError(w, http.StatusNotFound, h.CodeLinkNotFound, "Shortened URL not found")
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/health"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Liveness reports whether the process works, a failure means it should be restarted
+func Liveness(checker *health.Checker) httprouter.Handle {
+	return healthReport(checker.Live)
+}
+
+// Readiness reports whether the service can take requests, it fails while the
+// database is unreachable or not migrated and while the server is shutting down
+func Readiness(checker *health.Checker) httprouter.Handle {
+	return healthReport(checker.Ready)
+}
+
+func healthReport(run func(ctx context.Context) *health.Report) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		report := run(r.Context())
+
+		response := h.HealthResponse{
+			Status:     report.Status,
+			Components: make(map[string]h.ComponentResponse, len(report.Components)),
+		}
+		for name, component := range report.Components {
+			response.Components[name] = h.ComponentResponse{Status: component.Status, Error: component.Error}
+		}
+		status := http.StatusOK
+		if !report.OK() {
+			status = http.StatusServiceUnavailable
+		}
+		// probes must always see the current state
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,393 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/webhooks"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxListLimit caps the page size of a listing
+const MaxListLimit = 500
+
+// ListURLs lists the shortened links of a domain, the links can be filtered by owner
+// and tag and searched by title, notes and original URL using the owner, tag and q
+// query parameters. Only the owner, with its token, and the administrator may list
+// the links of an owner
+func ListURLs(sd models.ShortenerDataInterface, links *LinkBuilder) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		query := r.URL.Query()
+		filter := &models.ListFilter{
+			Domain: targetDomain(r),
+			Owner:  strings.TrimSpace(query.Get("owner")),
+			Tag:    utils.NormalizeTag(query.Get("tag")),
+			Search: strings.TrimSpace(query.Get("q")),
+		}
+		if filter.Owner != "" {
+			switch err := AuthorizeOwner(r.Context(), filter.Owner); {
+			case errors.Is(err, auth.ErrUnauthenticated):
+				utils.SendError(w, http.StatusUnauthorized, h.CodeUnauthorized, "Listing the links of an owner needs its token or the admin token")
+				return
+			case errors.Is(err, auth.ErrForbidden):
+				utils.SendError(w, http.StatusForbidden, h.CodeForbidden, "The links of another owner can't be listed")
+				return
+			}
+		}
+
+		var err error
+		if filter.Limit, err = queryInt(query.Get("limit"), MaxListLimit); err != nil {
+			sendFieldError(w, http.StatusBadRequest, fieldError("limit", "invalid_number", "Invalid limit"))
+			return
+		}
+		if filter.Offset, err = queryInt(query.Get("offset"), -1); err != nil {
+			sendFieldError(w, http.StatusBadRequest, fieldError("offset", "invalid_number", "Invalid offset"))
+			return
+		}
+
+		list, err := sd.List(r.Context(), filter)
+		if err != nil {
+			sendStorageError(w, err, "Unable to list the links")
+			return
+		}
+
+		response := h.LinksResponse{Links: make([]h.LinkResponse, 0, len(list))}
+		for _, data := range list {
+			response.Links = append(response.Links, linkResponse(r, links, data))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// GetURL returns a shortened link without redirecting or counting a click, it is
+// looked up on the domain of the request or the domain query parameter. Expired and
+// deactivated links are returned too
+func GetURL(sd models.ShortenerDataInterface, links *LinkBuilder) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		shortenedURLKey := ps.ByName("shortenedURLKey")
+		if !utils.IsValidURLKey(shortenedURLKey) {
+			sendInvalidKey(w)
+			return
+		}
+
+		data, err := sd.Get(r.Context(), targetDomain(r), shortenedURLKey)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				sendLinkNotFound(w)
+				return
+			}
+			sendStorageError(w, err, "Unable to look up the link")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(linkResponse(r, links, data))
+	}
+}
+
+// UpdateURL updates the title, notes, tags, interstitial flag and expiry of a shortened link,
+// the link is looked up on the domain of the request or the domain query parameter. Only
+// the owner of the link and the administrator may update it
+func UpdateURL(sd models.ShortenerDataInterface, links *LinkBuilder, hooks *webhooks.Dispatcher) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		shortenedURLKey := ps.ByName("shortenedURLKey")
+		if !utils.IsValidURLKey(shortenedURLKey) {
+			sendInvalidKey(w)
+			return
+		}
+		if err := AuthorizeLink(r.Context(), sd, targetDomain(r), shortenedURLKey); err != nil {
+			sendAuthorizeError(w, err)
+			return
+		}
+
+		var req h.LinkUpdateRequest
+		err := DecodeJSON(w, r, &req)
+		if err != nil {
+			SendBodyError(w, err)
+			return
+		}
+
+		update, invalid := LinkUpdate(&req)
+		if invalid != nil {
+			sendFieldError(w, http.StatusBadRequest, invalid)
+			return
+		}
+
+		data, err := sd.Update(r.Context(), targetDomain(r), shortenedURLKey, update)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				sendLinkNotFound(w)
+				return
+			}
+			sendStorageError(w, err, "Unable to update the link")
+			return
+		}
+		if hooks != nil {
+			hooks.Notify(r.Context(), models.EventLinkUpdated, data)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(linkResponse(r, links, data))
+	}
+}
+
+// DeactivateURL soft deletes a shortened link, it stops redirecting but is kept with its clicks.
+// Only the owner of the link and the administrator may deactivate it
+func DeactivateURL(sd models.ShortenerDataInterface, hooks *webhooks.Dispatcher) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		shortenedURLKey := ps.ByName("shortenedURLKey")
+		if !utils.IsValidURLKey(shortenedURLKey) {
+			sendInvalidKey(w)
+			return
+		}
+		if err := AuthorizeLink(r.Context(), sd, targetDomain(r), shortenedURLKey); err != nil {
+			sendAuthorizeError(w, err)
+			return
+		}
+
+		data, err := sd.Deactivate(r.Context(), targetDomain(r), shortenedURLKey)
+		if err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				sendLinkNotFound(w)
+				return
+			}
+			sendStorageError(w, err, "Unable to deactivate the link")
+			return
+		}
+		if hooks != nil {
+			hooks.Notify(r.Context(), models.EventLinkDeactivated, data)
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// AuthorizeLink checks the caller of ctx may change a link, it is shared by every API
+// that changes links. It fails with auth.ErrUnauthenticated for an anonymous caller,
+// models.ErrNotFound for an unknown link and auth.ErrForbidden unless the caller is
+// the administrator or the owner of the link
+func AuthorizeLink(ctx context.Context, sd models.ShortenerDataInterface, domain, shortenedURLKey string) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return auth.ErrUnauthenticated
+	}
+	data, err := sd.Get(ctx, domain, shortenedURLKey)
+	if err != nil {
+		return err
+	}
+	if !principal.CanManage(data.Owner) {
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// AuthorizeOwner checks the caller may list the links of owner, only the owner with its
+// token and the administrator may
+func AuthorizeOwner(ctx context.Context, owner string) error {
+	principal, ok := auth.FromContext(ctx)
+	if !ok {
+		return auth.ErrUnauthenticated
+	}
+	if !principal.CanManage(owner) {
+		return auth.ErrForbidden
+	}
+	return nil
+}
+
+// sendAuthorizeError answers a request whose link couldn't be authorized by AuthorizeLink
+func sendAuthorizeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		utils.SendError(w, http.StatusUnauthorized, h.CodeUnauthorized, "Changing a link needs the token of its owner or the admin token")
+	case errors.Is(err, auth.ErrForbidden):
+		utils.SendError(w, http.StatusForbidden, h.CodeForbidden, "The link belongs to another owner")
+	case errors.Is(err, models.ErrNotFound):
+		sendLinkNotFound(w)
+	default:
+		sendStorageError(w, err, "Unable to look up the link")
+	}
+}
+
+// LinkUpdate checks the fields of an update request and turns them into the update of
+// the link, it returns the invalid field if a field is invalid
+func LinkUpdate(req *h.LinkUpdateRequest) (*models.LinkUpdate, *h.FieldError) {
+	update := &models.LinkUpdate{Notes: req.Notes, Interstitial: req.Interstitial}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		update.Title = &title
+	}
+	var invalid *h.FieldError
+	if req.Tags != nil {
+		var tags []string
+		tags, invalid = NormalizeTags(*req.Tags)
+		update.Tags = &tags
+	}
+	if invalid == nil && update.Title != nil {
+		invalid = ValidateMetadata(*update.Title, "")
+	}
+	if invalid == nil && update.Notes != nil {
+		invalid = ValidateMetadata("", *update.Notes)
+	}
+	if invalid == nil && req.ExpiresAt != nil {
+		var expiresAt time.Time
+		if *req.ExpiresAt != "" {
+			var err error
+			expiresAt, err = time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				invalid = fieldError("expires_at", "invalid_expiry", "Expiry must be an RFC 3339 time")
+			}
+		}
+		update.ExpiresAt = &expiresAt
+	}
+	if invalid != nil {
+		return nil, invalid
+	}
+	return update, nil
+}
+
+func linkResponse(r *http.Request, links *LinkBuilder, data *models.ShortenerData) h.LinkResponse {
+	tags := data.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	response := h.LinkResponse{
+		Domain:       data.Domain,
+		Owner:        data.Owner,
+		Key:          data.ShortenedURLKEY,
+		ShortURL:     links.ShortURL(r, data.Domain, data.ShortenedURLKEY),
+		OriginalURL:  data.OriginalURL,
+		Clicks:       data.Clicks,
+		Title:        data.Title,
+		Notes:        data.Notes,
+		Tags:         tags,
+		Interstitial: data.Interstitial,
+		Active:       !data.Deactivated,
+		Expired:      data.IsExpired(time.Now()),
+	}
+	if !data.ExpiresAt.IsZero() {
+		response.ExpiresAt = &data.ExpiresAt
+	}
+	if !data.Created.IsZero() {
+		response.Created = &data.Created
+	}
+	if !data.Page.FetchedAt.IsZero() {
+		response.Page = &h.PageResponse{
+			Title:       data.Page.Title,
+			Description: data.Page.Description,
+			ImageURL:    data.Page.ImageURL,
+			FaviconURL:  data.Page.FaviconURL,
+			FetchedAt:   data.Page.FetchedAt,
+		}
+	}
+	return response
+}
+
+// NormalizeTags normalizes and dedupes the tags, it returns the invalid field if a tag is invalid
+func NormalizeTags(tags []string) ([]string, *h.FieldError) {
+	if len(tags) > MaxTags {
+		return nil, fieldError("tags", "too_many_tags", fmt.Sprintf("A link can have at most %d tags", MaxTags))
+	}
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = utils.NormalizeTag(tag)
+		if !utils.IsValidTag(tag) {
+			return nil, fieldError("tags", "invalid_tag", fmt.Sprintf("Invalid tag %q", tag))
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized, nil
+}
+
+// ValidateMetadata returns the invalid field if the title or notes are too long
+func ValidateMetadata(title, notes string) *h.FieldError {
+	if len(title) > MaxTitleLength {
+		return fieldError("title", "too_long", fmt.Sprintf("Title exceeds the maximum length of %d characters", MaxTitleLength))
+	}
+	if len(notes) > MaxNotesLength {
+		return fieldError("notes", "too_long", fmt.Sprintf("Notes exceed the maximum length of %d characters", MaxNotesLength))
+	}
+	return nil
+}
+
+// ValidateLink checks a link that is created without the shorten endpoint, by the
+// command line or an import, and normalizes its tags. The checks are the ones of the
+// shorten endpoint except that the destination isn't contacted and the owner of the
+// link is trusted, both callers are the administrator
+func ValidateLink(ctx context.Context, domains models.DomainInterface, link *models.ShortenerData) error {
+	if !utils.IsValidURL(link.OriginalURL) {
+		return errors.New("Invalid URL")
+	}
+	if len(link.OriginalURL) > MaxURLLength {
+		return fmt.Errorf("URL exceeds the maximum length of %d characters", MaxURLLength)
+	}
+	if link.ShortenedURLKEY != "" && !utils.IsValidURLKey(link.ShortenedURLKEY) {
+		return fmt.Errorf("Invalid key %q", link.ShortenedURLKEY)
+	}
+	if utils.IsReservedKey(link.ShortenedURLKEY) {
+		return fmt.Errorf("Key %q is the name of a route", link.ShortenedURLKEY)
+	}
+	tags, invalid := NormalizeTags(link.Tags)
+	if invalid == nil {
+		invalid = ValidateMetadata(link.Title, link.Notes)
+	}
+	if invalid != nil {
+		return invalid
+	}
+	link.Tags = tags
+	if link.Clicks < 0 {
+		return errors.New("Clicks can't be negative")
+	}
+	if link.Owner != "" && !utils.IsValidOwner(link.Owner) {
+		return errors.New("Invalid owner")
+	}
+	if link.Domain == models.DefaultDomain {
+		return nil
+	}
+
+	domain, err := lookupDomain(ctx, domains, link.Domain)
+	if errors.Is(err, models.ErrNotFound) {
+		return fmt.Errorf("Unknown domain %q", link.Domain)
+	}
+	if err != nil {
+		return err
+	}
+	if !domain.AllowsOwner(link.Owner) {
+		return errors.New("Owner is not allowed to use this domain")
+	}
+	link.Domain = domain.Host
+	return nil
+}
+
+// queryInt parses an optional non negative integer query parameter, max caps the value when positive
+func queryInt(value string, max int) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid number")
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	return n, nil
+}
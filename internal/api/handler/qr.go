@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/qr"
+	"go-url-shortener/internal/utils"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// QRCode renders the short URL of a key as a QR code. The format (png or svg), size
+// in pixels, error correction level (L, M, Q or H) and margin in modules can be set
+// with the format, size, level and margin query parameters
+func QRCode(sd models.ShortenerDataInterface, links *LinkBuilder) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		shortenedURLKey := ps.ByName("shortenedURLKey")
+		if !utils.IsValidURLKey(shortenedURLKey) {
+			sendInvalidKey(w)
+			return
+		}
+
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = qr.PNG
+		}
+		if format != qr.PNG && format != qr.SVG {
+			sendFieldError(w, http.StatusBadRequest, fieldError("format", "unknown_format", "Format must be png or svg"))
+			return
+		}
+		opts, err := qrOptions(query.Get("size"), query.Get("level"), query.Get("margin"))
+		if err != nil {
+			utils.SendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Only render codes for links that exist
+		domain := requestDomain(r).Host
+		if _, err = sd.Get(r.Context(), domain, shortenedURLKey); err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				sendLinkNotFound(w)
+				return
+			}
+			sendStorageError(w, err, "Unable to look up the shortened URL")
+			return
+		}
+
+		code, err := qr.Encode(links.ShortURL(r, domain, shortenedURLKey), opts)
+		if err != nil {
+			utils.SendErrorResponse(w, "Unable to create the QR code", http.StatusInternalServerError)
+			return
+		}
+
+		// Render into a buffer so an encoding error can still be reported
+		var buf bytes.Buffer
+		contentType := "image/png"
+		if format == qr.SVG {
+			contentType = "image/svg+xml"
+			err = code.WriteSVG(&buf)
+		} else {
+			err = code.WritePNG(&buf)
+		}
+		if err != nil {
+			utils.SendErrorResponse(w, "Unable to create the QR code", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.WriteHeader(http.StatusOK)
+		buf.WriteTo(w)
+	}
+}
+
+// qrOptions parses the QR code query parameters, empty values keep the defaults
+func qrOptions(size, level, margin string) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	var err error
+	if size != "" {
+		if opts.Size, err = strconv.Atoi(size); err != nil {
+			return opts, errors.New("size must be a number")
+		}
+	}
+	if level != "" {
+		opts.Level = level
+	}
+	if margin != "" {
+		if opts.Margin, err = strconv.Atoi(margin); err != nil {
+			return opts, errors.New("margin must be a number")
+		}
+	}
+	return opts, opts.Validate()
+}
+
+// qrDataURI renders a QR code of content with the default options as a PNG data URI
+func qrDataURI(content string) (string, error) {
+	code, err := qr.Encode(content, qr.DefaultOptions())
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err = code.WritePNG(&buf); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
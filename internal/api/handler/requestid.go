@@ -0,0 +1,44 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+const requestIDContextKey = contextKey("request-id")
+
+// MaxRequestIDLength is the longest X-Request-ID accepted from a client
+const MaxRequestIDLength = 128
+
+// NewRequestID generates a random request ID
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// IsValidRequestID reports whether a request ID sent by a client can be kept,
+// it must be short and printable so it can't forge log lines
+func IsValidRequestID(id string) bool {
+	if id == "" || len(id) > MaxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// ContextWithRequestID stores the ID of a request
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestID returns the ID of the request, empty when it has none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"fmt"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/proxy"
+	"go-url-shortener/internal/utils"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultPrefix is the path the redirects are served under unless configured otherwise
+const DefaultPrefix = "/s"
+
+// reservedPaths are used by the API and can't be the redirect prefix
+var reservedPaths = []string{"/ping", "/shorten", "/links", "/domains", "/metrics", "/healthz", "/readyz", "/webhooks",
+	"/export", "/import", "/backups", "/api", "/openapi.json", "/docs", "/graphql"}
+
+// LinkBuilder builds the public short URLs handed out by the API
+type LinkBuilder struct {
+	// BaseURL is the canonical public URL of the service, when nil the scheme and
+	// host are taken from the request
+	BaseURL *url.URL
+	// Prefix is the path the redirects are served under, "" serves the keys at the root
+	Prefix string
+	// Proxies are trusted to report the scheme and host the client used
+	Proxies proxy.Proxies
+}
+
+// ValidatePrefix checks the redirect prefix can be routed, it must start with a slash,
+// can't end with one and can't be a path used by the API
+func ValidatePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("redirect prefix %q must start with a slash and not end with one", prefix)
+	}
+	if strings.ContainsAny(prefix, ":*?#") {
+		return fmt.Errorf("redirect prefix %q can't contain :, *, ? or #", prefix)
+	}
+	for _, reserved := range reservedPaths {
+		if prefix == reserved || strings.HasPrefix(prefix, reserved+"/") {
+			return fmt.Errorf("redirect prefix %q conflicts with %s", prefix, reserved)
+		}
+	}
+	return nil
+}
+
+// ShortURL builds the short URL of a key. Links of a branded domain use the host of
+// the domain, links in the default namespace use the configured base URL or else the
+// host the client used
+func (b *LinkBuilder) ShortURL(r *http.Request, domain, shortenedURLKey string) string {
+	shortenedURL := url.URL{
+		Scheme: b.Proxies.Scheme(r),
+		Host:   b.Proxies.Host(r),
+	}
+	if b.BaseURL != nil {
+		shortenedURL.Scheme = b.BaseURL.Scheme
+		shortenedURL.Host = b.BaseURL.Host
+		shortenedURL.Path = strings.TrimSuffix(b.BaseURL.Path, "/")
+	}
+	if domain != models.DefaultDomain && utils.NormalizeHost(shortenedURL.Host) != domain {
+		shortenedURL.Host = domain
+	}
+	shortenedURL.Path += b.Prefix + "/" + shortenedURLKey
+	return shortenedURL.String()
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"net/http"
+)
+
+// StorageErrorCode is the error code of a failed storage call, a query that ran out of
+// time or a link that got no unused key is temporary and the client can retry
+func StorageErrorCode(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return h.CodeTimeout
+	}
+	var exhausted *models.KeyExhaustedError
+	if errors.As(err, &exhausted) {
+		return h.CodeKeyExhausted
+	}
+	return h.CodeInternal
+}
+
+// sendStorageError answers a request whose storage call failed, a query that ran out
+// of time or a link that got no unused key is reported as unavailable so the client
+// knows it can retry
+func sendStorageError(w http.ResponseWriter, err error, message string) {
+	switch StorageErrorCode(err) {
+	case h.CodeTimeout:
+		utils.SendError(w, http.StatusServiceUnavailable, h.CodeTimeout, "The database did not answer in time")
+	case h.CodeKeyExhausted:
+		utils.SendError(w, http.StatusServiceUnavailable, h.CodeKeyExhausted, "Unable to generate an unused key, please retry")
+	default:
+		utils.SendErrorResponse(w, message, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/metrics"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("go-url-shortener/internal/api/handler")
+
+// redirectOutcome counts the outcome of a short link lookup and notes it on the span
+func redirectOutcome(span trace.Span, outcome string) {
+	metrics.Redirect(outcome)
+	span.SetAttributes(attribute.String("redirect.outcome", outcome))
+	if outcome == metrics.RedirectError {
+		span.SetStatus(codes.Error, "unable to redirect")
+	}
+}
+
+// shortenOutcome counts the outcome of a shorten request and notes it on the span
+func shortenOutcome(span trace.Span, outcome string) {
+	metrics.Shorten(outcome)
+	span.SetAttributes(attribute.String("shorten.outcome", outcome))
+	if outcome == metrics.ShortenError {
+		span.SetStatus(codes.Error, "unable to shorten")
+	}
+}
+
+// validationFailure counts a shorten request rejected for the given reason and notes it on the span
+func validationFailure(span trace.Span, reason string) {
+	metrics.ValidationFailure(reason)
+	span.SetAttributes(
+		attribute.String("shorten.outcome", metrics.ShortenInvalid),
+		attribute.String("shorten.validation_failure", reason),
+	)
+}
+
+// rejectShorten counts a shorten request rejected because of the invalid field, the
+// code of the field is the reason of the rejection
+func rejectShorten(w http.ResponseWriter, span trace.Span, status int, invalid *h.FieldError) {
+	validationFailure(span, invalid.Code)
+	sendFieldError(w, status, invalid)
+}
@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/transfer"
+	"go-url-shortener/internal/utils"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// MaxImportSize bounds the body of an import
+const MaxImportSize = 32 << 20
+
+// exportFlushInterval is how many links are written between two flushes of an export
+const exportFlushInterval = 100
+
+// ExportLinks streams every link of every domain as NDJSON or CSV, picked with the
+// format query parameter. The links are sent while they are read so an export of any
+// size uses little memory, a failure after the first bytes were sent aborts the response
+func ExportLinks(sd models.ShortenerDataInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		format := r.URL.Query().Get("format")
+		if format == "" {
+			format = transfer.FormatNDJSON
+		}
+		if !slices.Contains(transfer.ExportFormats, format) {
+			sendFieldError(w, http.StatusBadRequest, fieldError("format", "unknown_format", "Unknown export format"))
+			return
+		}
+
+		w.Header().Set("Content-Type", transfer.ContentType(format))
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf(`attachment; filename="links-%s.%s"`, time.Now().UTC().Format("20060102"), format))
+		sent := &countingWriter{w: w}
+		out, err := transfer.NewWriter(sent, format)
+		if err == nil {
+			rc := http.NewResponseController(w)
+			n := 0
+			err = sd.Export(r.Context(), func(data *models.ShortenerData) error {
+				if err := out.Write(transfer.FromLink(data)); err != nil {
+					return err
+				}
+				if n++; n%exportFlushInterval == 0 {
+					if err := out.Flush(); err != nil {
+						return err
+					}
+					rc.Flush()
+				}
+				return nil
+			})
+		}
+		if err == nil {
+			err = out.Flush()
+		}
+		if err != nil {
+			if sent.n > 0 {
+				// the status was sent already, cutting the connection tells the client the export is incomplete
+				panic(http.ErrAbortHandler)
+			}
+			sendStorageError(w, err, "Unable to export the links")
+		}
+	}
+}
+
+// countingWriter counts the bytes written to the client
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// ImportLinks imports the links in the body, in the format of the format query parameter.
+// Links keep their keys and the domain query parameter moves every link to that domain.
+// Links whose key or URL is already used are skipped and reported, nothing is imported
+// when a record is invalid or for a dry run with dry_run=1
+func ImportLinks(sd models.ShortenerDataInterface, domains models.DomainInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if format == "" {
+			format = transfer.FormatNDJSON
+		}
+		if !slices.Contains(transfer.ImportFormats, format) {
+			sendFieldError(w, http.StatusBadRequest, fieldError("format", "unknown_format", "Unknown import format"))
+			return
+		}
+		dryRun, _ := strconv.ParseBool(query.Get("dry_run"))
+		_, moveDomain := query["domain"]
+
+		reader, err := transfer.NewReader(http.MaxBytesReader(w, r.Body, MaxImportSize), format)
+		if err != nil {
+			sendImportError(w, err)
+			return
+		}
+		links := []*models.ShortenerData{}
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				sendImportError(w, err)
+				return
+			}
+			link := record.Link()
+			if moveDomain {
+				link.Domain = query.Get("domain")
+			}
+			link.Domain = utils.NormalizeHost(link.Domain)
+			if err = ValidateLink(r.Context(), domains, link); err != nil {
+				sendInvalidRecord(w, len(links)+1, err)
+				return
+			}
+			links = append(links, link)
+		}
+
+		result, err := sd.Import(r.Context(), links, dryRun)
+		if err != nil {
+			sendStorageError(w, err, "Unable to import the links")
+			return
+		}
+
+		response := h.ImportResponse{
+			Imported:  result.Imported,
+			DryRun:    dryRun,
+			Conflicts: make([]h.ImportConflictResponse, 0, len(result.Conflicts)),
+		}
+		for _, conflict := range result.Conflicts {
+			response.Conflicts = append(response.Conflicts, h.ImportConflictResponse{
+				Record:      conflict.Index + 1,
+				Domain:      conflict.Domain,
+				Key:         conflict.Key,
+				OriginalURL: conflict.OriginalURL,
+				Reason:      conflict.Reason,
+				Existing:    conflict.Existing,
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// sendInvalidRecord rejects an import because of an invalid record, the invalid
+// field of the record is detailed when it is known
+func sendInvalidRecord(w http.ResponseWriter, record int, err error) {
+	message := fmt.Sprintf("record %d: %s", record, err)
+	var invalid *h.FieldError
+	if !errors.As(err, &invalid) {
+		utils.SendError(w, http.StatusBadRequest, h.CodeValidationFailed, message)
+		return
+	}
+	utils.SendError(w, http.StatusBadRequest, h.CodeValidationFailed, message, h.FieldError{
+		Field:   fmt.Sprintf("records[%d].%s", record-1, invalid.Field),
+		Code:    invalid.Code,
+		Message: invalid.Message,
+	})
+}
+
+// sendImportError answers an import whose body couldn't be read
+func sendImportError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		utils.SendErrorResponse(w, fmt.Sprintf("Import exceeds the maximum size of %d bytes", MaxImportSize),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	utils.SendErrorResponse(w, "Invalid import: "+err.Error(), http.StatusBadRequest)
+}
@@ -1,44 +1,111 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/metrics"
 	"go-url-shortener/internal/models"
 	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/web"
+	"go-url-shortener/internal/webhooks"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Max length for URLs
 const MaxURLLength = 2048
 
+// Max lengths for the link metadata
+const (
+	MaxTitleLength = 256
+	MaxNotesLength = 4096
+	MaxTags        = 20
+)
+
 // openShortenedURL retrives the original URL using the shortened URL provided,
-// then redirect the user to the original URL
-func OpenShortenedURL(sd models.ShortenerDataInterface) httprouter.Handle {
+// then redirect the user to the original URL. Appending + to the key or adding
+// ?preview=1 shows a preview page instead of redirecting, and links with the
+// interstitial flag show a "you are leaving" page before the redirect. When hooks
+// is set the webhooks of the owner are told when a link reaches their click threshold
+func OpenShortenedURL(sd models.ShortenerDataInterface, links *LinkBuilder, hooks *webhooks.Dispatcher) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		ctx, span := tracer.Start(r.Context(), "OpenShortenedURL")
+		defer span.End()
+		r = r.WithContext(ctx)
+
 		// Retrieve the shortened URL from the path parameter
 		shortenedURLKey := ps.ByName("shortenedURLKey")
+		preview, _ := strconv.ParseBool(r.URL.Query().Get("preview"))
+		if key, ok := strings.CutSuffix(shortenedURLKey, "+"); ok {
+			shortenedURLKey = key
+			preview = true
+		}
 		if !utils.IsValidURLKey(shortenedURLKey) {
-			utils.SendErrorResponse(w, "Shortened URL is invalid", http.StatusBadRequest)
+			redirectOutcome(span, metrics.RedirectInvalidKey)
+			sendInvalidKey(w)
 			return
 		}
 
-		// Check if the shortened URL exists in the db
-		data, err := sd.Get(shortenedURLKey)
+		// Check if the shortened URL exists in the namespace of the domain
+		domain := requestDomain(r).Host
+		span.SetAttributes(attribute.String("link.domain", domain), attribute.String("link.key", shortenedURLKey))
+		data, err := sd.Get(r.Context(), domain, shortenedURLKey)
 		if err != nil {
-			utils.SendErrorResponse(w, "Shortened URL not found", http.StatusNotFound)
+			if errors.Is(err, models.ErrNotFound) {
+				redirectOutcome(span, metrics.RedirectNotFound)
+				sendLinkNotFound(w)
+				return
+			}
+			span.RecordError(err)
+			redirectOutcome(span, metrics.RedirectError)
+			sendStorageError(w, err, "Unable to look up the shortened URL")
+			return
+		}
+		if data.IsExpired(time.Now()) {
+			redirectOutcome(span, metrics.RedirectExpired)
+			utils.SendError(w, http.StatusGone, h.CodeLinkExpired, "Shortened URL has expired")
+			return
+		}
+		if data.Deactivated {
+			redirectOutcome(span, metrics.RedirectDeactivated)
+			utils.SendError(w, http.StatusGone, h.CodeLinkDeactivated, "Shortened URL was deactivated")
+			return
+		}
+
+		// A preview only shows where the link goes, it doesn't count as a click
+		if preview {
+			redirectOutcome(span, metrics.RedirectPreview)
+			renderLinkPage(w, r, links, web.PreviewPage, data)
 			return
 		}
 
 		// Increase the clicks for monitor purpose
-		err = sd.IncreaseClicks(shortenedURLKey)
+		clicks, err := sd.IncreaseClicks(r.Context(), domain, shortenedURLKey)
 		if err != nil {
-			utils.SendErrorResponse(w, "Unable to update the clicks", http.StatusInternalServerError)
+			span.RecordError(err)
+			redirectOutcome(span, metrics.RedirectError)
+			sendStorageError(w, err, "Unable to update the clicks")
+			return
+		}
+		redirectOutcome(span, metrics.RedirectHit)
+		if hooks != nil {
+			hooks.Clicked(r.Context(), data, clicks)
+		}
+
+		if data.Interstitial {
+			renderLinkPage(w, r, links, web.InterstitialPage, data)
 			return
 		}
 
@@ -47,70 +114,227 @@ func OpenShortenedURL(sd models.ShortenerDataInterface) httprouter.Handle {
 	}
 }
 
-func ShortenedURL(sd models.ShortenerDataInterface) httprouter.Handle {
+// renderLinkPage renders the preview or interstitial page of a link
+func renderLinkPage(w http.ResponseWriter, r *http.Request, links *LinkBuilder, page string, data *models.ShortenerData) {
+	linkPage := web.LinkPage{
+		ShortURL:    links.ShortURL(r, data.Domain, data.ShortenedURLKEY),
+		OriginalURL: data.OriginalURL,
+		Title:       data.Title,
+		Description: data.Page.Description,
+		ImageURL:    data.Page.ImageURL,
+		FaviconURL:  data.Page.FaviconURL,
+		Clicks:      data.Clicks,
+	}
+	if u, err := url.Parse(data.OriginalURL); err == nil {
+		linkPage.Host = u.Hostname()
+	}
+	// fall back to the title of the destination page when the link has no title
+	if linkPage.Title == "" {
+		linkPage.Title = data.Page.Title
+	}
+
+	if err := web.Render(w, http.StatusOK, page, linkPage); err != nil {
+		utils.SendErrorResponse(w, "Unable to render the page", http.StatusInternalServerError)
+	}
+}
+
+// ShortenedURL shortens the URL in the request body, when pages is set the metadata
+// of the destination page is fetched in the background for new links. The link is
+// created on the domain of the request unless the body asks for another domain.
+// When hooks is set the webhooks of the owner are told about new links. The body
+// is JSON, a form or the URL as plain text, and only the short URL is answered
+// as plain text to the clients that prefer it over JSON
+func ShortenedURL(sd models.ShortenerDataInterface, domains models.DomainInterface, pages *metadata.Queue, links *LinkBuilder,
+	hooks *webhooks.Dispatcher) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		var req h.URLRequest
-		// Decode the JSON from request body
-		err := json.NewDecoder(r.Body).Decode(&req)
+		ctx, span := tracer.Start(r.Context(), "ShortenedURL")
+		defer span.End()
+		r = r.WithContext(ctx)
+
+		// Decode the request body
+		req, err := decodeURLRequest(w, r)
 		if err != nil {
-			utils.SendErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+			sendURLRequestError(w, span, err)
 			return
 		}
 
-		// Check if the URL is empty or missing
-		if strings.TrimSpace(req.URL) == "" {
-			utils.SendErrorResponse(w, "Missing url in the request payload", http.StatusBadRequest)
+		link, msg, err := Shorten(r.Context(), sd, domains, pages, hooks, req)
+		if err != nil {
+			var invalid *h.FieldError
+			if errors.As(err, &invalid) {
+				sendFieldError(w, RejectionStatus(invalid), invalid)
+				return
+			}
+			sendStorageError(w, err, err.Error())
 			return
 		}
 
-		// Check if the URL is valid
-		if !utils.IsValidURL(req.URL) {
-			utils.SendErrorResponse(w, "Invalid URL", http.StatusBadRequest)
-			return
+		response := h.URLResponse{
+			Result:  links.ShortURL(r, link.Domain, link.ShortenedURLKEY),
+			Message: msg,
 		}
-
-		// Check if the URL is too long
-		if len(req.URL) > MaxURLLength {
-			utils.SendErrorResponse(w, fmt.Sprintf("URL exceeds the maximum length of %d characters", MaxURLLength), http.StatusBadRequest)
+		if prefersText(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprintln(w, response.Result)
 			return
 		}
-
-		// Check if the URL is genuine
-		if !utils.CheckGenuineURL(req.URL) {
-			utils.SendErrorResponse(w, "The URL was not reachable", http.StatusBadRequest)
-			return
+		if req.QR {
+			if response.QR, err = qrDataURI(response.Result); err != nil {
+				utils.SendErrorResponse(w, "Unable to create the QR code", http.StatusInternalServerError)
+				return
+			}
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
 
-		// TODO add a rate limit
-		// TODO add a blacklist for banned urls
-		// TODO check speical characters
+// Shorten checks a shorten request and stores its link, it is shared by every API that
+// creates links. The link goes to the domain stored in ctx by ContextWithDomain unless
+// req asks for another domain. A rejected request returns a *h.FieldError, other errors
+// come from the storage. The returned link holds its domain and key, and the message
+// tells whether it was created or the URL was already shortened on the domain
+func Shorten(ctx context.Context, sd models.ShortenerDataInterface, domains models.DomainInterface, pages *metadata.Queue,
+	hooks *webhooks.Dispatcher, req *h.URLRequest) (*models.ShortenerData, string, error) {
+	span := trace.SpanFromContext(ctx)
+	reject := func(invalid *h.FieldError) (*models.ShortenerData, string, error) {
+		validationFailure(span, invalid.Code)
+		return nil, "", invalid
+	}
 
-		// Handle concurrent processes
-		var mu sync.Mutex
-		mu.Lock()
-		shortenedURLKey, msg, err := sd.Insert(req.URL, 0)
-		mu.Unlock()
+	// Check if the URL is empty or missing
+	if strings.TrimSpace(req.URL) == "" {
+		return reject(fieldError("url", "missing_url", "Missing url in the request payload"))
+	}
 
-		if len(shortenedURLKey) != utils.URLKeyLength || err != nil {
-			utils.SendErrorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
+	// Check if the URL is valid
+	if !utils.IsValidURL(req.URL) {
+		return reject(fieldError("url", "invalid_url", "Invalid URL"))
+	}
+
+	// Check if the URL is too long
+	if len(req.URL) > MaxURLLength {
+		return reject(fieldError("url", "url_too_long", fmt.Sprintf("URL exceeds the maximum length of %d characters", MaxURLLength)))
+	}
+
+	// Check the title, notes and tags
+	tags, invalid := NormalizeTags(req.Tags)
+	if invalid == nil {
+		invalid = ValidateMetadata(req.Title, req.Notes)
+	}
+	if invalid != nil {
+		validationFailure(span, "invalid_metadata")
+		return nil, "", invalid
+	}
+
+	// Check the expiry is in the future
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+		if !expiresAt.After(time.Now()) {
+			return reject(fieldError("expires_at", "invalid_expiry", "Expiry must be in the future"))
 		}
-		scheme := "http"
-		if r.TLS != nil {
-			scheme = "https"
+	}
+
+	// Check the owner is allowed to use the target domain, an owner token shortens for
+	// its owner and only the administrator may name any owner
+	owner := strings.TrimSpace(req.Owner)
+	if owner != "" && !utils.IsValidOwner(owner) {
+		return reject(fieldError("owner", "invalid_owner", "Invalid owner"))
+	}
+	principal, authenticated := auth.FromContext(ctx)
+	if authenticated && !principal.Admin {
+		if owner != "" && owner != principal.Owner {
+			return reject(fieldError("owner", "owner_not_allowed", "Owner doesn't match the token"))
 		}
-		shortenedURL := url.URL{
-			Scheme: scheme,
-			Host:   r.Host,
-			Path:   fmt.Sprintf("/s/%s", shortenedURLKey),
+		owner = principal.Owner
+	}
+	domain := DomainFromContext(ctx)
+	if req.Domain != "" {
+		var err error
+		domain, err = lookupDomain(ctx, domains, req.Domain)
+		if errors.Is(err, models.ErrNotFound) {
+			return reject(fieldError("domain", "unknown_domain", "Unknown domain"))
 		}
+		if err != nil {
+			shortenOutcome(span, metrics.ShortenError)
+			return nil, "", fmt.Errorf("Unable to look up the domain: %w", err)
+		}
+	}
+	if len(domain.Owners) > 0 && !authenticated {
+		return reject(fieldError("owner", "owner_not_allowed", "This domain needs the token of one of its owners"))
+	}
+	if !domain.AllowsOwner(owner) {
+		return reject(fieldError("owner", "owner_not_allowed", "Owner is not allowed to use this domain"))
+	}
 
-		response := h.URLResponse{
-			Result:  shortenedURL.String(),
-			Message: msg,
+	// Check if the URL is genuine, this contacts the destination so it is done last
+	if !utils.CheckGenuineURL(req.URL) {
+		return reject(fieldError("url", "unreachable", "The URL was not reachable"))
+	}
+
+	// TODO add a rate limit
+	// TODO add a blacklist for banned urls
+	// TODO check speical characters
+
+	// Insert is safe under concurrency, two requests for the same URL get the same key
+	link := &models.ShortenerData{
+		Domain:       domain.Host,
+		Owner:        owner,
+		OriginalURL:  req.URL,
+		Title:        strings.TrimSpace(req.Title),
+		Notes:        req.Notes,
+		Tags:         tags,
+		Interstitial: req.Interstitial,
+		ExpiresAt:    expiresAt,
+	}
+	shortenedURLKey, msg, err := sd.Insert(ctx, link)
+	if err != nil {
+		span.RecordError(err)
+		shortenOutcome(span, metrics.ShortenError)
+		return nil, "", err
+	}
+	link.ShortenedURLKEY = shortenedURLKey
+	span.SetAttributes(attribute.String("link.domain", domain.Host), attribute.String("link.key", shortenedURLKey))
+	if msg == models.MsgShortened {
+		shortenOutcome(span, metrics.ShortenCreated)
+		if pages != nil {
+			pages.Enqueue(domain.Host, shortenedURLKey, req.URL)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(response)
+		if hooks != nil {
+			hooks.Notify(ctx, models.EventLinkCreated, link)
+		}
+	} else {
+		shortenOutcome(span, metrics.ShortenDeduplicated)
+	}
+	return link, msg, nil
+}
+
+// RejectionStatus is the HTTP status of a shorten request rejected because of the invalid field
+func RejectionStatus(invalid *h.FieldError) int {
+	if invalid.Code == "owner_not_allowed" {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// sendURLRequestError answers a shorten request whose body couldn't be read
+func sendURLRequestError(w http.ResponseWriter, span trace.Span, err error) {
+	var invalid *h.FieldError
+	switch {
+	case errors.As(err, &invalid):
+		rejectShorten(w, span, http.StatusBadRequest, invalid)
+	case isTooLarge(err):
+		validationFailure(span, "body_too_large")
+		sendTooLarge(w)
+	case errors.Is(err, errUnsupportedMediaType):
+		validationFailure(span, "unsupported_media_type")
+		utils.SendError(w, http.StatusUnsupportedMediaType, h.CodeUnsupportedMediaType,
+			"Content type must be application/json, application/x-www-form-urlencoded or text/plain")
+	default:
+		validationFailure(span, "invalid_json")
+		sendInvalidJSON(w)
 	}
 }
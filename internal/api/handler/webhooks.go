@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/webhooks"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// CreateWebhook registers a webhook for the links of the owner of the caller. The secret
+// the payloads are signed with is generated here and only sent back in this response
+func CreateWebhook(store models.WebhookInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		var req h.WebhookRequest
+		err := DecodeJSON(w, r, &req)
+		if err != nil {
+			SendBodyError(w, err)
+			return
+		}
+
+		owner, ok := webhookOwner(w, r, req.Owner, "Missing owner in the request payload")
+		if !ok {
+			return
+		}
+		if !utils.IsValidURL(req.URL) {
+			sendFieldError(w, http.StatusBadRequest, fieldError("url", "invalid_url", "Invalid webhook URL"))
+			return
+		}
+		if len(req.URL) > MaxURLLength {
+			sendFieldError(w, http.StatusBadRequest,
+				fieldError("url", "url_too_long", fmt.Sprintf("URL exceeds the maximum length of %d characters", MaxURLLength)))
+			return
+		}
+		events := make([]string, 0, len(req.Events))
+		for _, event := range req.Events {
+			if !models.IsWebhookEvent(event) {
+				sendFieldError(w, http.StatusBadRequest, fieldError("events", "unknown_event", fmt.Sprintf("Unknown event %q", event)))
+				return
+			}
+			events = append(events, event)
+		}
+		if req.ClickThreshold < 0 {
+			sendFieldError(w, http.StatusBadRequest, fieldError("click_threshold", "negative", "Click threshold can't be negative"))
+			return
+		}
+
+		webhook := &models.Webhook{
+			Owner:          owner,
+			URL:            req.URL,
+			Secret:         webhooks.NewSecret(),
+			Events:         events,
+			ClickThreshold: req.ClickThreshold,
+		}
+		if err = store.CreateWebhook(r.Context(), webhook); err != nil {
+			sendStorageError(w, err, "Unable to create the webhook")
+			return
+		}
+
+		response := webhookResponse(webhook)
+		response.Secret = webhook.Secret
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// ListWebhooks lists the webhooks of the owner of the caller
+func ListWebhooks(store models.WebhookInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		owner, ok := webhookOwner(w, r, r.URL.Query().Get("owner"), "Missing owner query parameter")
+		if !ok {
+			return
+		}
+
+		list, err := store.ListWebhooks(r.Context(), owner)
+		if err != nil {
+			sendStorageError(w, err, "Unable to list the webhooks")
+			return
+		}
+
+		response := h.WebhooksResponse{Webhooks: make([]h.WebhookResponse, 0, len(list))}
+		for _, webhook := range list {
+			response.Webhooks = append(response.Webhooks, webhookResponse(webhook))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(response)
+	}
+}
+
+// DeleteWebhook removes a webhook of the owner of the caller, its pending deliveries are dropped
+func DeleteWebhook(store models.WebhookInterface) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		owner, ok := webhookOwner(w, r, r.URL.Query().Get("owner"), "Missing owner query parameter")
+		if !ok {
+			return
+		}
+		id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+		if err != nil || id < 1 {
+			sendFieldError(w, http.StatusBadRequest, fieldError("id", "invalid_id", "Invalid webhook id"))
+			return
+		}
+
+		if err = store.DeleteWebhook(r.Context(), owner, id); err != nil {
+			if errors.Is(err, models.ErrNotFound) {
+				utils.SendErrorResponse(w, "Webhook not found", http.StatusNotFound)
+				return
+			}
+			sendStorageError(w, err, "Unable to delete the webhook")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// webhookOwner returns the owner whose webhooks the caller manages. An owner token only
+// manages the webhooks of its owner, the administrator names the owner in requested
+func webhookOwner(w http.ResponseWriter, r *http.Request, requested, missing string) (string, bool) {
+	principal, ok := auth.FromContext(r.Context())
+	if !ok {
+		utils.SendError(w, http.StatusUnauthorized, h.CodeUnauthorized, "Webhooks need an owner token or the admin token")
+		return "", false
+	}
+	requested = strings.TrimSpace(requested)
+	if !principal.Admin {
+		if requested != "" && requested != principal.Owner {
+			utils.SendError(w, http.StatusForbidden, h.CodeForbidden, "The webhooks of another owner can't be managed")
+			return "", false
+		}
+		return principal.Owner, true
+	}
+	if requested == "" {
+		sendFieldError(w, http.StatusBadRequest, fieldError("owner", "missing_owner", missing))
+		return "", false
+	}
+	if !utils.IsValidOwner(requested) {
+		sendFieldError(w, http.StatusBadRequest, fieldError("owner", "invalid_owner", "Invalid owner"))
+		return "", false
+	}
+	return requested, true
+}
+
+func webhookResponse(webhook *models.Webhook) h.WebhookResponse {
+	events := webhook.Events
+	if events == nil {
+		events = []string{}
+	}
+	return h.WebhookResponse{
+		ID:             webhook.ID,
+		Owner:          webhook.Owner,
+		URL:            webhook.URL,
+		Events:         events,
+		ClickThreshold: webhook.ClickThreshold,
+		Created:        webhook.Created,
+	}
+}
@@ -1,6 +1,44 @@
 package http
 
+import "time"
+
 // URLRequest for /shortened endpoint resquest
 type URLRequest struct {
 	URL string `json:"url"`
+	// Domain is the host of the domain to create the link on, the domain of the request is used when empty
+	Domain string   `json:"domain,omitempty"`
+	Owner  string   `json:"owner,omitempty"`
+	Title  string   `json:"title,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// Interstitial shows a "you are leaving" page before redirecting
+	Interstitial bool `json:"interstitial,omitempty"`
+	// ExpiresAt is when the link stops redirecting, the link never expires when it is not set
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// QR adds a QR code of the short URL to the response
+	QR bool `json:"qr,omitempty"`
+}
+
+// LinkUpdateRequest for /links/:key endpoint request, only the fields present are updated
+type LinkUpdateRequest struct {
+	Title        *string   `json:"title"`
+	Notes        *string   `json:"notes"`
+	Tags         *[]string `json:"tags"`
+	Interstitial *bool     `json:"interstitial"`
+	// ExpiresAt is an RFC 3339 time, an empty string removes the expiry
+	ExpiresAt *string `json:"expires_at"`
+}
+
+// DomainRequest for /domains/:host endpoint request
+type DomainRequest struct {
+	RootRedirect string   `json:"root_redirect"`
+	Owners       []string `json:"owners"`
+}
+
+// WebhookRequest registers a webhook of an owner, an empty list of events subscribes to every event
+type WebhookRequest struct {
+	Owner          string   `json:"owner"`
+	URL            string   `json:"url"`
+	Events         []string `json:"events"`
+	ClickThreshold int      `json:"click_threshold"`
 }
@@ -1,7 +1,200 @@
 package http
 
+import (
+	"net/http"
+	"time"
+)
+
 // URLResponse for /shortened endpoint response
 type URLResponse struct {
 	Result  string `json:"result,omitempty"`
 	Message string `json:"message,omitempty"`
+	// QR is a PNG data URI of the short URL, only set when asked for
+	QR string `json:"qr,omitempty"`
+}
+
+// LinkResponse describes a single shortened link
+type LinkResponse struct {
+	Domain       string        `json:"domain"`
+	Owner        string        `json:"owner"`
+	Key          string        `json:"key"`
+	ShortURL     string        `json:"short_url"`
+	OriginalURL  string        `json:"original_url"`
+	Clicks       int           `json:"clicks"`
+	Title        string        `json:"title"`
+	Notes        string        `json:"notes"`
+	Tags         []string      `json:"tags"`
+	Interstitial bool          `json:"interstitial"`
+	ExpiresAt    *time.Time    `json:"expires_at,omitempty"`
+	Active       bool          `json:"active"`
+	Expired      bool          `json:"expired"`
+	Created      *time.Time    `json:"created,omitempty"`
+	Page         *PageResponse `json:"page,omitempty"`
+}
+
+// PageResponse is the metadata of the destination page, it is only set once the page was fetched
+type PageResponse struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	ImageURL    string    `json:"image_url"`
+	FaviconURL  string    `json:"favicon_url"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// LinksResponse for /links endpoint response
+type LinksResponse struct {
+	Links []LinkResponse `json:"links"`
+}
+
+// DomainResponse describes a branded short domain
+type DomainResponse struct {
+	Host         string   `json:"host"`
+	RootRedirect string   `json:"root_redirect"`
+	Owners       []string `json:"owners"`
+}
+
+// DomainsResponse for /domains endpoint response
+type DomainsResponse struct {
+	Domains []DomainResponse `json:"domains"`
+}
+
+// HealthResponse for /healthz and /readyz endpoints response
+type HealthResponse struct {
+	Status     string                       `json:"status"`
+	Components map[string]ComponentResponse `json:"components"`
+}
+
+// ComponentResponse is the status of one component of the service
+type ComponentResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// WebhookResponse describes a webhook, the secret is only sent when the webhook is created
+type WebhookResponse struct {
+	ID             int64     `json:"id"`
+	Owner          string    `json:"owner"`
+	URL            string    `json:"url"`
+	Events         []string  `json:"events"`
+	ClickThreshold int       `json:"click_threshold"`
+	Secret         string    `json:"secret,omitempty"`
+	Created        time.Time `json:"created"`
+}
+
+// WebhooksResponse for /webhooks endpoint response
+type WebhooksResponse struct {
+	Webhooks []WebhookResponse `json:"webhooks"`
+}
+
+// ImportConflictResponse is a record of an import that was skipped
+type ImportConflictResponse struct {
+	// Record is the number of the record in the import, starting at 1
+	Record      int    `json:"record"`
+	Domain      string `json:"domain"`
+	Key         string `json:"key"`
+	OriginalURL string `json:"original_url"`
+	Reason      string `json:"reason"`
+	// Existing is the key already used for the URL, or the URL already using the key
+	Existing string `json:"existing"`
+}
+
+// ImportResponse for /import endpoint response
+type ImportResponse struct {
+	Imported  int                      `json:"imported"`
+	DryRun    bool                     `json:"dry_run"`
+	Conflicts []ImportConflictResponse `json:"conflicts"`
+}
+
+// BackupResponse describes a backup of the database
+type BackupResponse struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BackupsResponse for /backups endpoint response
+type BackupsResponse struct {
+	Backups []BackupResponse `json:"backups"`
+}
+
+// RequestIDHeader carries the ID of a request, it is repeated in the error responses
+const RequestIDHeader = "X-Request-ID"
+
+// Codes of the error responses, clients branch on the code rather than on the message
+const (
+	CodeInvalidRequest       = "invalid_request"
+	CodeInvalidJSON          = "invalid_json"
+	CodeValidationFailed     = "validation_failed"
+	CodeInvalidKey           = "invalid_key"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeLinkNotFound         = "link_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeLinkExpired          = "link_expired"
+	CodeLinkDeactivated      = "link_deactivated"
+	CodeGone                 = "gone"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeInternal             = "internal_error"
+	CodeUnavailable          = "unavailable"
+	CodeTimeout              = "timeout"
+	CodeKeyExhausted         = "key_exhausted"
+)
+
+// StatusCode returns the generic code of an error status
+func StatusCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusGone:
+		return CodeGone
+	case http.StatusRequestEntityTooLarge:
+		return CodePayloadTooLarge
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status < http.StatusInternalServerError {
+		return CodeInvalidRequest
+	}
+	return CodeInternal
+}
+
+// ErrorResponse is the body of every error response, Message repeats Error.Message
+// for the clients written before the envelope
+type ErrorResponse struct {
+	Message string    `json:"message"`
+	Error   ErrorBody `json:"error"`
+}
+
+// ErrorBody describes an error, Details lists the invalid fields of a rejected request
+type ErrorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError is an invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FieldError) Error() string {
+	return e.Message
 }
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"go-url-shortener/internal/api/handler"
+	h "go-url-shortener/internal/api/http"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of a request, an ID sent by the client is kept
+// so a request can be followed across services
+const RequestIDHeader = h.RequestIDHeader
+
+type routeContextKey struct{}
+
+// routeInfo is filled in by the router so the access log knows which route and key were hit
+type routeInfo struct {
+	route string
+	key   string
+}
+
+// withRouteInfo returns the route info of the request, it is added to the context
+// by the first middleware that asks for it
+func withRouteInfo(r *http.Request) (*http.Request, *routeInfo) {
+	if info, ok := r.Context().Value(routeContextKey{}).(*routeInfo); ok {
+		return r, info
+	}
+	info := &routeInfo{}
+	return r.WithContext(context.WithValue(r.Context(), routeContextKey{}, info)), info
+}
+
+// setRoute records the matched route and key for the access log
+func setRoute(r *http.Request, route, key string) {
+	if info, ok := r.Context().Value(routeContextKey{}).(*routeInfo); ok {
+		info.route = route
+		info.key = key
+	}
+}
+
+// requestID gives every request an ID, taken from the X-Request-ID header when it
+// is valid, and sends it back in the response
+func (app *App) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !handler.IsValidRequestID(id) {
+			id = handler.NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(handler.ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// accessLog writes one line per request once it is served, server errors are logged as errors
+func (app *App) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		r, info := withRouteInfo(r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", handler.RequestID(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", info.route),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", app.links.Proxies.ClientIP(r)),
+		}
+		if info.key != "" {
+			attrs = append(attrs, slog.String("key", info.key))
+		}
+		if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+		}
+		app.logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,21 @@
+// Package openapi holds the OpenAPI document of the API and the Swagger UI that browses it
+package openapi
+
+import (
+	"embed"
+
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+//go:embed openapi.json swagger-initializer.js
+var files embed.FS
+
+// Spec is the OpenAPI 3 document of the API, it is kept by hand next to the routes
+var Spec, _ = files.ReadFile("openapi.json")
+
+// Initializer starts the Swagger UI on the document served at /openapi.json, it
+// replaces the one of the Swagger UI distribution which loads an example API
+var Initializer, _ = files.ReadFile("swagger-initializer.js")
+
+// UI holds the files of the Swagger UI
+var UI = swaggerFiles.FS
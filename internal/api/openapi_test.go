@@ -0,0 +1,239 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"go-url-shortener/internal/api/handler"
+	"go-url-shortener/internal/api/openapi"
+	"go-url-shortener/internal/backup"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/models/mocks"
+	"go-url-shortener/internal/utils/test"
+	"go-url-shortener/internal/webhooks"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers/legacy"
+)
+
+// TestOpenAPI sends requests to every operation of the OpenAPI document and checks
+// the live responses, their status and body, are the ones the document describes
+func TestOpenAPI(t *testing.T) {
+	ctx := context.Background()
+	doc, err := openapi3.NewLoader().LoadFromData(openapi.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = doc.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, contentType := range []string{"text/html", "text/csv", "application/x-ndjson", "image/png", "image/svg+xml"} {
+		openapi3filter.RegisterBodyDecoder(contentType, openapi3filter.FileBodyDecoder)
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store := &mocks.MockWebhooks{}
+	dispatcher := webhooks.NewDispatcher(store, http.DefaultClient, slog.New(slog.NewTextHandler(io.Discard, nil)),
+		webhooks.Options{Interval: time.Hour})
+	defer dispatcher.Shutdown(ctx)
+	domains := &mocks.MockDomains{
+		MockData: map[string]*models.Domain{
+			"go.example.com":  {Host: "go.example.com", RootRedirect: "https://example.com/"},
+			"old.example.com": {Host: "old.example.com"},
+		},
+		Links: map[string]int{"go.example.com": 1},
+	}
+	app := NewApp(mockDB(), WithDomains(domains), WithWebhooks(store, dispatcher),
+		WithBackups(&backup.Dir{DB: db, Path: filepath.Join(t.TempDir(), "backups")}), WithTokens(testTokens(t)))
+	ts := test.NewTestServer(t, app.Routes())
+	defer ts.Close()
+
+	// the document is served relative to the host the client used
+	doc.Servers = openapi3.Servers{{URL: ts.URL}}
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := []struct {
+		method, path, body string
+		// contentType is sent for the body, host overrides the Host header
+		contentType, host string
+	}{
+		{"GET", "/", "", "", "go.example.com"},
+		{"GET", "/", "", "", ""},
+		{"GET", "/s/abcabc1234567890", "", "", ""},
+		{"GET", "/s/abcabc1234567890?preview=1", "", "", ""},
+		{"GET", "/s/abcabc1234560000", "", "", ""},
+		{"GET", "/s/abcabc1234561111", "", "", ""},
+		{"GET", "/s/abcabc0000000000", "", "", ""},
+		{"GET", "/s/abc.def", "", "", ""},
+		{"GET", "/s/abcabc1234567890/qr", "", "", ""},
+		{"GET", "/s/abcabc1234567890/qr?format=svg", "", "", ""},
+		{"GET", "/s/abcabc1234567890/qr?format=gif", "", "", ""},
+		{"POST", "/api/v1/shorten", `{"url":"ftp://example.com/"}`, "", ""},
+		{"POST", "/api/v1/shorten", `{"url":"https://example.com/","domain":"unknown.example.com"}`, "", ""},
+		{"POST", "/api/v1/shorten", `{`, "", ""},
+		{"POST", "/api/v1/shorten", "url=ftp%3A%2F%2Fexample.com%2F", "application/x-www-form-urlencoded", ""},
+		{"POST", "/api/v1/shorten", "ftp://example.com/", "text/plain", ""},
+		{"POST", "/api/v1/shorten", "<url/>", "application/xml", ""},
+		{"POST", "/api/v1/shorten", `{"notes":"` + strings.Repeat("a", handler.MaxBodySize) + `"}`, "", ""},
+		{"GET", "/api/v1/links?tag=code", "", "", ""},
+		{"GET", "/api/v1/links?owner=marketing", "", "", ""},
+		{"GET", "/api/v1/links?limit=ten", "", "", ""},
+		{"GET", "/api/v1/links/abcabc1234567890", "", "", ""},
+		{"GET", "/api/v1/links/abcabc0000000000", "", "", ""},
+		{"GET", "/api/v1/links/a.b", "", "", ""},
+		{"PATCH", "/api/v1/links/abcabc1234567890", `{"title":"Code","tags":["code","git"]}`, "", ""},
+		{"PATCH", "/api/v1/links/abcabc1234567890", `{"expires_at":"tomorrow"}`, "", ""},
+		{"PATCH", "/api/v1/links/abcabc0000000000", `{"title":"Code"}`, "", ""},
+		{"DELETE", "/api/v1/links/abcabc1234560000", "", "", ""},
+		{"DELETE", "/api/v1/links/abcabc0000000000", "", "", ""},
+		{"GET", "/api/v1/export", "", "", ""},
+		{"GET", "/api/v1/export?format=csv", "", "", ""},
+		{"GET", "/api/v1/export?format=xml", "", "", ""},
+		{"POST", "/api/v1/import?dry_run=1", `{"key":"abcabc1234567890","original_url":"https://example.org/"}`, "", ""},
+		{"POST", "/api/v1/import", `{"original_url":"not a url"}`, "", ""},
+		{"GET", "/api/v1/domains", "", "", ""},
+		{"PUT", "/api/v1/domains/go.example.com", `{"root_redirect":"https://example.com/","owners":["marketing"]}`, "", ""},
+		{"PUT", "/api/v1/domains/go.example.com", `{"root_redirect":"example"}`, "", ""},
+		{"DELETE", "/api/v1/domains/go.example.com", "", "", ""},
+		{"DELETE", "/api/v1/domains/old.example.com", "", "", ""},
+		{"DELETE", "/api/v1/domains/missing.example.com", "", "", ""},
+		{"POST", "/api/v1/webhooks", `{"owner":"marketing","url":"https://example.com/hook","events":["link.created"]}`, "", ""},
+		{"POST", "/api/v1/webhooks", `{"owner":"marketing","url":"https://example.com/hook","events":["link.opened"]}`, "", ""},
+		{"GET", "/api/v1/webhooks?owner=marketing", "", "", ""},
+		{"GET", "/api/v1/webhooks?owner=", "", "", ""},
+		{"DELETE", "/api/v1/webhooks/1?owner=marketing", "", "", ""},
+		{"DELETE", "/api/v1/webhooks/1?owner=marketing", "", "", ""},
+		{"POST", "/api/v1/backups", "", "", ""},
+		{"GET", "/api/v1/backups", "", "", ""},
+		{"POST", "/graphql", `{"query":"{ links { key ownerStats { clicks } } stats { links } }"}`, "", ""},
+		{"POST", "/graphql", `{"query":"{ link(key: \"a/b\") { key } }"}`, "", ""},
+		{"POST", "/graphql", `{}`, "", ""},
+		{"GET", "/ping", "", "", ""},
+		{"GET", "/healthz", "", "", ""},
+		{"GET", "/readyz", "", "", ""},
+		{"GET", "/metrics", "", "", ""},
+		{"GET", "/openapi.json", "", "", ""},
+	}
+
+	called := map[*openapi3.Operation]bool{}
+	// send sends a request with the token and checks the response matches the document
+	send := func(t *testing.T, method, path, body, contentType, host, token string) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if host != "" {
+			req.Host = host
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rs, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rs.Body.Close()
+		respBody, err := io.ReadAll(rs.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		route, params, err := router.FindRoute(req)
+		if err != nil {
+			t.Fatalf("the document has no operation for the request: %v", err)
+		}
+		called[route.Operation] = true
+		input := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{Request: req, PathParams: params, Route: route},
+			Status:                 rs.StatusCode,
+			Header:                 rs.Header,
+			Body:                   io.NopCloser(bytes.NewReader(respBody)),
+			Options:                &openapi3filter.Options{IncludeResponseStatus: true},
+		}
+		if err = openapi3filter.ValidateResponse(ctx, input); err != nil {
+			t.Errorf("got %d %s: %v", rs.StatusCode, respBody, err)
+		}
+	}
+	for _, tc := range requests {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			send(t, tc.method, tc.path, tc.body, tc.contentType, tc.host, adminToken)
+		})
+	}
+	// the administration routes answer 401 without the admin token, the webhooks and
+	// the changes of links without a token and 403 for the token of another owner
+	for _, tc := range []struct{ method, path, body, token string }{
+		{"GET", "/api/v1/export", "", ""},
+		{"POST", "/api/v1/import", `{"original_url":"https://example.org/"}`, ""},
+		{"POST", "/api/v1/backups", "", ""},
+		{"GET", "/api/v1/backups", "", ""},
+		{"GET", "/api/v1/webhooks", "", ""},
+		{"POST", "/api/v1/webhooks", `{"owner":"sales","url":"https://example.com/hook"}`, marketingToken},
+		{"DELETE", "/api/v1/webhooks/1", "", ""},
+		{"GET", "/api/v1/links?owner=marketing", "", ""},
+		{"GET", "/api/v1/links?owner=sales", "", marketingToken},
+		{"PATCH", "/api/v1/links/abcabc1234567890", `{"title":"Code"}`, ""},
+		{"PATCH", "/api/v1/links/abcabc1234567890", `{"title":"Code"}`, marketingToken},
+		{"DELETE", "/api/v1/links/abcabc1234560000", "", ""},
+		{"DELETE", "/api/v1/links/abcabc1234567890", "", marketingToken},
+		{"GET", "/api/v1/domains", "", ""},
+		{"PUT", "/api/v1/domains/go.example.com", `{"owners":["marketing"]}`, marketingToken},
+		{"DELETE", "/api/v1/domains/go.example.com", "", ""},
+	} {
+		t.Run("restricted "+tc.method+" "+tc.path, func(t *testing.T) {
+			send(t, tc.method, tc.path, tc.body, "", "", tc.token)
+		})
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method, operation := range item.Operations() {
+			if !called[operation] {
+				t.Errorf("%s %s was not tested", method, path)
+			}
+		}
+	}
+
+	for _, tc := range []test.TestCases{
+		{
+			Name:                    "Swagger UI",
+			Method:                  "GET",
+			URLPath:                 "/docs/",
+			ExpectedStatusCode:      http.StatusOK,
+			ExpectedResponseMessage: `<div id="swagger-ui"></div>`,
+		},
+		{
+			Name:                    "Swagger UI loads the document of the service",
+			Method:                  "GET",
+			URLPath:                 "/docs/swagger-initializer.js",
+			ExpectedStatusCode:      http.StatusOK,
+			ExpectedResponseMessage: `url: "../openapi.json"`,
+		},
+		{
+			Name:               "Swagger UI bundle",
+			Method:             "GET",
+			URLPath:            "/docs/swagger-ui-bundle.js",
+			ExpectedStatusCode: http.StatusOK,
+		},
+	} {
+		t.Run(tc.Name, func(t *testing.T) {
+			test.RunTestCase(t, ts, tc)
+		})
+	}
+}
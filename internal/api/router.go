@@ -1,36 +1,252 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/api/graphql"
 	"go-url-shortener/internal/api/handler"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/backup"
+	"go-url-shortener/internal/health"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/metrics"
 	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/webhooks"
+	"io"
+	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 )
 
 type App struct {
-	urls models.ShortenerDataInterface
+	urls    models.ShortenerDataInterface
+	domains models.DomainInterface
+	pages   *metadata.Queue
+	links   *handler.LinkBuilder
+	logger  *slog.Logger
+	health  *health.Checker
+	hooks   models.WebhookInterface
+	// dispatcher is told about the changes of links, it is nil when webhooks are disabled
+	dispatcher *webhooks.Dispatcher
+	backups    *backup.Dir
+	// tokens authenticate the callers, the administration routes are closed when it is nil
+	tokens *auth.Tokens
 }
 
-func NewApp(dataInterface models.ShortenerDataInterface) *App {
-	return &App{
-		urls: dataInterface,
+// Option configures the optional parts of the App
+type Option func(*App)
+
+// WithLogger writes the access log and the errors of the handlers to logger
+func WithLogger(logger *slog.Logger) Option {
+	return func(app *App) {
+		app.logger = logger
+	}
+}
+
+// WithHealth serves the checks of checker on /healthz and /readyz
+func WithHealth(checker *health.Checker) Option {
+	return func(app *App) {
+		app.health = checker
+	}
+}
+
+// WithWebhooks serves the webhooks of owners on /webhooks and sends them the events of their links
+func WithWebhooks(store models.WebhookInterface, dispatcher *webhooks.Dispatcher) Option {
+	return func(app *App) {
+		app.hooks = store
+		app.dispatcher = dispatcher
+	}
+}
+
+// WithBackups serves the backups of the database on /backups
+func WithBackups(backups *backup.Dir) Option {
+	return func(app *App) {
+		app.backups = backups
+	}
+}
+
+// WithTokens authenticates the callers with tokens, the administration routes need the admin token
+func WithTokens(tokens *auth.Tokens) Option {
+	return func(app *App) {
+		app.tokens = tokens
 	}
 }
 
+// WithPageMetadata fetches the metadata of the destination page of new links in the background
+func WithPageMetadata(pages *metadata.Queue) Option {
+	return func(app *App) {
+		app.pages = pages
+	}
+}
+
+// WithDomains serves the registered branded domains, each with its own key namespace
+func WithDomains(domains models.DomainInterface) Option {
+	return func(app *App) {
+		app.domains = domains
+	}
+}
+
+// WithLinks configures the public base URL, the redirect prefix and the trusted proxies
+func WithLinks(links *handler.LinkBuilder) Option {
+	return func(app *App) {
+		app.links = links
+	}
+}
+
+func NewApp(dataInterface models.ShortenerDataInterface, opts ...Option) *App {
+	app := &App{
+		urls:   dataInterface,
+		links:  &handler.LinkBuilder{Prefix: handler.DefaultPrefix},
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		health: health.New(),
+	}
+	for _, opt := range opts {
+		opt(app)
+	}
+	return app
+}
+
 // pong just writes pong to response to test if the server is working
 func pong(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	w.Write([]byte("pong"))
 }
 
+// serveMetrics serves the Prometheus metrics
+func serveMetrics(h http.Handler) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		h.ServeHTTP(w, r)
+	}
+}
+
+// resolveDomain picks the domain of the request from the Host header so the
+// handlers work in the key namespace of that domain
+func (app *App) resolveDomain(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.domains != nil {
+			domain, err := app.domains.GetDomain(r.Context(), utils.NormalizeHost(r.Host))
+			switch {
+			case err == nil:
+				r = r.WithContext(handler.ContextWithDomain(r.Context(), domain))
+			case !errors.Is(err, models.ErrNotFound):
+				app.logger.ErrorContext(r.Context(), "failed to resolve the domain",
+					"request_id", handler.RequestID(r.Context()), "host", r.Host, "error", err)
+				utils.SendErrorResponse(w, "Unable to resolve the domain", http.StatusInternalServerError)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rootKeys routes the keys served at the root, httprouter can't register a root
+// level wildcard next to /ping and /shorten so they are routed when nothing else matched
+func rootKeys(open, qrCode httprouter.Handle) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if r.Method != http.MethodGet || key == "" {
+			notFound(w, r)
+			return
+		}
+		ps := httprouter.Params{{Key: "shortenedURLKey", Value: key}}
+		setRoute(r, "/:shortenedURLKey", key)
+		switch rest {
+		case "":
+			open(w, r, ps)
+		case "qr":
+			qrCode(w, r, ps)
+		default:
+			notFound(w, r)
+		}
+	})
+}
+
+// notFound answers the paths that match no route
+func notFound(w http.ResponseWriter, r *http.Request) {
+	utils.SendErrorResponse(w, "Page not found", http.StatusNotFound)
+}
+
+// methodNotAllowed answers a route called with another method, the router sets
+// the Allow header to the methods of the route
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	utils.SendErrorResponse(w, fmt.Sprintf("Method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+}
+
+// APIPrefix is the path the current version of the API is served under
+const APIPrefix = "/api/v1"
+
+// handleAPI registers a route of the API under APIPrefix, and at path without
+// the prefix where the first release served it. The unversioned route is
+// deprecated, its responses point to the versioned one
+func handleAPI(router *httprouter.Router, method, path string, h httprouter.Handle) {
+	handle(router, method, APIPrefix+path, h)
+	handle(router, method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"successor-version\"", APIPrefix, r.URL.Path))
+		h(w, r, ps)
+	})
+}
+
+// handle registers a route that is counted, timed and logged under its path pattern
+func handle(router *httprouter.Router, method, path string, h httprouter.Handle) {
+	router.Handle(method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		setRoute(r, path, ps.ByName("shortenedURLKey"))
+		metrics.Instrument(path, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h(w, r, ps)
+		})).ServeHTTP(w, r)
+	})
+}
+
 // Routes creates the application's routing table
 func (app *App) Routes() http.Handler {
 	router := httprouter.New()
-	router.GET("/ping", pong)
-	router.GET("/s/:shortenedURLKey", handler.OpenShortenedURL(app.urls))
-	router.POST("/shorten", handler.ShortenedURL(app.urls))
-	standard := alice.New()
+	handle(router, http.MethodGet, "/", handler.DomainRoot())
+	handle(router, http.MethodGet, "/ping", pong)
+	handle(router, http.MethodGet, "/healthz", handler.Liveness(app.health))
+	handle(router, http.MethodGet, "/readyz", handler.Readiness(app.health))
+	handle(router, http.MethodGet, "/metrics", serveMetrics(metrics.Handler()))
+	handle(router, http.MethodGet, "/openapi.json", handler.OpenAPI())
+	handle(router, http.MethodGet, "/docs/*filepath", handler.Docs())
+	handle(router, http.MethodPost, "/graphql", graphql.Handler(app.urls, app.domains, app.pages, app.links, app.dispatcher))
+
+	open := handler.OpenShortenedURL(app.urls, app.links, app.dispatcher)
+	qrCode := handler.QRCode(app.urls, app.links)
+	if prefix := app.links.Prefix; prefix != "" {
+		handle(router, http.MethodGet, prefix+"/:shortenedURLKey", open)
+		handle(router, http.MethodGet, prefix+"/:shortenedURLKey/qr", qrCode)
+	} else {
+		router.NotFound = metrics.Instrument("/:shortenedURLKey", rootKeys(open, qrCode))
+	}
+	if router.NotFound == nil {
+		router.NotFound = http.HandlerFunc(notFound)
+	}
+	router.MethodNotAllowed = http.HandlerFunc(methodNotAllowed)
+
+	handleAPI(router, http.MethodPost, "/shorten", handler.ShortenedURL(app.urls, app.domains, app.pages, app.links, app.dispatcher))
+	handleAPI(router, http.MethodGet, "/links", handler.ListURLs(app.urls, app.links))
+	handleAPI(router, http.MethodGet, "/links/:shortenedURLKey", handler.GetURL(app.urls, app.links))
+	handleAPI(router, http.MethodPatch, "/links/:shortenedURLKey", requireAuth(handler.UpdateURL(app.urls, app.links, app.dispatcher)))
+	handleAPI(router, http.MethodDelete, "/links/:shortenedURLKey", requireAuth(handler.DeactivateURL(app.urls, app.dispatcher)))
+	handleAPI(router, http.MethodGet, "/export", requireAdmin(handler.ExportLinks(app.urls)))
+	handleAPI(router, http.MethodPost, "/import", requireAdmin(handler.ImportLinks(app.urls, app.domains)))
+	if app.domains != nil {
+		handleAPI(router, http.MethodGet, "/domains", requireAdmin(handler.ListDomains(app.domains)))
+		handleAPI(router, http.MethodPut, "/domains/:host", requireAdmin(handler.SaveDomain(app.domains)))
+		handleAPI(router, http.MethodDelete, "/domains/:host", requireAdmin(handler.DeleteDomain(app.domains)))
+	}
+	if app.hooks != nil {
+		handleAPI(router, http.MethodGet, "/webhooks", requireAuth(handler.ListWebhooks(app.hooks)))
+		handleAPI(router, http.MethodPost, "/webhooks", requireAuth(handler.CreateWebhook(app.hooks)))
+		handleAPI(router, http.MethodDelete, "/webhooks/:id", requireAuth(handler.DeleteWebhook(app.hooks)))
+	}
+	if app.backups != nil {
+		handleAPI(router, http.MethodGet, "/backups", requireAdmin(handler.ListBackups(app.backups)))
+		handleAPI(router, http.MethodPost, "/backups", requireAdmin(handler.CreateBackup(app.backups)))
+	}
+	standard := alice.New(app.requestID, app.trace, app.accessLog, app.resolveDomain, app.authenticate)
 
 	return standard.Then(router)
 }
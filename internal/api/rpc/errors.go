@@ -0,0 +1,76 @@
+package rpc
+
+import (
+	"errors"
+	"go-url-shortener/internal/api/handler"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/models"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorDomain names the service in the ErrorInfo details of the errors
+const errorDomain = "go-url-shortener"
+
+// invalidArgument rejects a request because of the invalid field, the details carry
+// the field and the code the HTTP API would answer
+func invalidArgument(invalid *h.FieldError) error {
+	code := codes.InvalidArgument
+	if handler.RejectionStatus(invalid) == http.StatusForbidden {
+		code = codes.PermissionDenied
+	}
+	st, err := status.New(code, invalid.Message).WithDetails(
+		&errdetails.ErrorInfo{Reason: invalid.Code, Domain: errorDomain},
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: invalid.Field, Description: invalid.Message},
+		}},
+	)
+	if err != nil {
+		return status.Error(code, invalid.Message)
+	}
+	return st.Err()
+}
+
+// authorizeError converts the error of the check of the caller of a change of a link
+func authorizeError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, "Changing a link needs the token of its owner or the admin token")
+	case errors.Is(err, auth.ErrForbidden):
+		return status.Error(codes.PermissionDenied, "The link belongs to another owner")
+	default:
+		return statusError(err, "Unable to look up the link")
+	}
+}
+
+// listOwnerError converts the error of the check of the caller listing the links of an owner
+func listOwnerError(err error) error {
+	if errors.Is(err, auth.ErrUnauthenticated) {
+		return status.Error(codes.Unauthenticated, "Listing the links of an owner needs its token or the admin token")
+	}
+	return status.Error(codes.PermissionDenied, "The links of another owner can't be listed")
+}
+
+// statusError converts the error of a shorten or storage call, message is used for
+// the errors that aren't the fault of the client and can't be retried
+func statusError(err error, message string) error {
+	var invalid *h.FieldError
+	if errors.As(err, &invalid) {
+		return invalidArgument(invalid)
+	}
+	if errors.Is(err, models.ErrNotFound) {
+		return status.Error(codes.NotFound, "Shortened URL not found")
+	}
+	switch handler.StorageErrorCode(err) {
+	case h.CodeTimeout:
+		return status.Error(codes.Unavailable, "The database did not answer in time")
+	case h.CodeKeyExhausted:
+		return status.Error(codes.Unavailable, "Unable to generate an unused key, please retry")
+	default:
+		return status.Error(codes.Internal, message)
+	}
+}
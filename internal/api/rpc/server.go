@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"context"
+	"go-url-shortener/internal/api/handler"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/api/rpc/shortenerpb"
+	"go-url-shortener/internal/auth"
+	"go-url-shortener/internal/metrics"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var tracer = otel.Tracer("go-url-shortener/internal/api/rpc")
+
+// requestIDKey is the metadata key of the request ID, gRPC lowercases the keys
+var requestIDKey = strings.ToLower(h.RequestIDHeader)
+
+// authorizationKey is the metadata key of the bearer token of a call
+const authorizationKey = "authorization"
+
+// NewServer creates a gRPC server serving svc. Every call gets a request ID, sent back
+// in the x-request-id header, and is traced, counted and logged like the HTTP requests.
+// The callers are identified by the bearer token of the authorization metadata, and a
+// call that panics fails with INTERNAL instead of stopping the server
+func NewServer(svc *Service, tokens *auth.Tokens, logger *slog.Logger) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(observe(logger), recoverPanic(logger), authenticate(tokens)))
+	shortenerpb.RegisterShortenerServer(server, svc)
+	return server
+}
+
+// observe is the interceptor of every call, it continues the trace of the client when
+// the metadata carries a W3C traceparent and keeps an x-request-id sent by the client
+func observe(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		md, _ := metadata.FromIncomingContext(ctx)
+		id := first(md.Get(requestIDKey))
+		if !handler.IsValidRequestID(id) {
+			id = handler.NewRequestID()
+		}
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+		ctx = handler.ContextWithRequestID(ctx, id)
+
+		ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+		ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.method", info.FullMethod),
+			attribute.String("request.id", id),
+		))
+		defer span.End()
+
+		resp, err := next(ctx, req)
+		code := status.Code(err)
+		span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+		if serverError(code) {
+			span.SetStatus(otelcodes.Error, code.String())
+		}
+		metrics.RPC(info.FullMethod, code.String(), time.Since(start))
+
+		level := slog.LevelInfo
+		if serverError(code) {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", info.FullMethod),
+			slog.String("code", code.String()),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			attrs = append(attrs, slog.String("client_ip", p.Addr.String()))
+		}
+		if sc := span.SpanContext(); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		logger.LogAttrs(ctx, level, "rpc", attrs...)
+		return resp, err
+	}
+}
+
+// recoverPanic turns a panic of a call into an INTERNAL error, the panic is logged with
+// its stack since grpc doesn't recover the handlers like net/http does
+func recoverPanic(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				logger.ErrorContext(ctx, "rpc panic", "request_id", handler.RequestID(ctx), "method", info.FullMethod,
+					"panic", p, "stack", string(debug.Stack()))
+				resp, err = nil, status.Error(codes.Internal, "Internal error")
+			}
+		}()
+		return next(ctx, req)
+	}
+}
+
+// authenticate identifies the caller like the HTTP API, a call without a token is
+// anonymous and a call with an unknown token is rejected
+func authenticate(tokens *auth.Tokens) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		header := first(md.Get(authorizationKey))
+		if header == "" {
+			return next(ctx, req)
+		}
+		token, ok := auth.BearerToken(header)
+		principal, known := tokens.Authenticate(token)
+		if !ok || !known {
+			return nil, status.Error(codes.Unauthenticated, "Invalid credentials")
+		}
+		return next(auth.NewContext(ctx, principal), req)
+	}
+}
+
+// serverError reports whether the code is a failure of the server rather than of the request
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// metadataCarrier lets the propagator read the trace context from the metadata of a call
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	return first(metadata.MD(c).Get(key))
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+var _ propagation.TextMapCarrier = metadataCarrier{}
@@ -0,0 +1,194 @@
+// Package rpc serves the Shortener gRPC service defined in proto/shortener/v1, on top
+// of the same storage and checks as the HTTP API
+package rpc
+
+//go:generate protoc -I ../../../proto --go_out=../../.. --go_opt=module=go-url-shortener --go-grpc_out=../../.. --go-grpc_opt=module=go-url-shortener shortener/v1/shortener.proto
+
+import (
+	"context"
+	"go-url-shortener/internal/api/handler"
+	h "go-url-shortener/internal/api/http"
+	"go-url-shortener/internal/api/rpc/shortenerpb"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/metrics"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/utils"
+	"go-url-shortener/internal/webhooks"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Service implements the Shortener service
+type Service struct {
+	shortenerpb.UnimplementedShortenerServer
+
+	URLs    models.ShortenerDataInterface
+	Domains models.DomainInterface
+	// Pages fetches the metadata of the destination page of new links when it is set
+	Pages *metadata.Queue
+	Links *handler.LinkBuilder
+	// Hooks is told about the changes and clicks of links when it is set
+	Hooks *webhooks.Dispatcher
+	// Host is the host of the short URLs of the default domain when the links have no base URL
+	Host string
+}
+
+// checkKey rejects a key that can't be a short link
+func checkKey(key string) error {
+	if !utils.IsValidURLKey(key) {
+		return invalidArgument(&h.FieldError{Field: "key", Code: h.CodeInvalidKey, Message: "Shortened URL is invalid"})
+	}
+	return nil
+}
+
+func (s *Service) Shorten(ctx context.Context, req *shortenerpb.ShortenRequest) (*shortenerpb.ShortenResponse, error) {
+	shorten := &h.URLRequest{
+		URL:          req.GetUrl(),
+		Domain:       req.GetDomain(),
+		Owner:        req.GetOwner(),
+		Title:        req.GetTitle(),
+		Notes:        req.GetNotes(),
+		Tags:         req.GetTags(),
+		Interstitial: req.GetInterstitial(),
+	}
+	if req.ExpiresAt != nil {
+		expiresAt := req.GetExpiresAt().AsTime()
+		shorten.ExpiresAt = &expiresAt
+	}
+	link, msg, err := handler.Shorten(ctx, s.URLs, s.Domains, s.Pages, s.Hooks, shorten)
+	if err != nil {
+		return nil, statusError(err, "Unable to shorten the URL")
+	}
+	// the URL may have been shortened before, the stored link is returned as it is
+	data, err := s.URLs.Get(ctx, link.Domain, link.ShortenedURLKEY)
+	if err != nil {
+		return nil, statusError(err, "Unable to look up the link")
+	}
+	return &shortenerpb.ShortenResponse{Link: s.link(data), Created: msg == models.MsgShortened, Message: msg}, nil
+}
+
+func (s *Service) Resolve(ctx context.Context, req *shortenerpb.ResolveRequest) (*shortenerpb.Link, error) {
+	if err := checkKey(req.GetKey()); err != nil {
+		return nil, err
+	}
+	domain := utils.NormalizeHost(req.GetDomain())
+	data, err := s.URLs.Get(ctx, domain, req.GetKey())
+	if err != nil {
+		return nil, statusError(err, "Unable to look up the shortened URL")
+	}
+	if data.IsExpired(time.Now()) {
+		return nil, status.Error(codes.FailedPrecondition, "Shortened URL has expired")
+	}
+	if data.Deactivated {
+		return nil, status.Error(codes.FailedPrecondition, "Shortened URL was deactivated")
+	}
+
+	if req.GetCountClick() {
+		clicks, err := s.URLs.IncreaseClicks(ctx, domain, req.GetKey())
+		if err != nil {
+			metrics.Redirect(metrics.RedirectError)
+			return nil, statusError(err, "Unable to update the clicks")
+		}
+		metrics.Redirect(metrics.RedirectHit)
+		data.Clicks = clicks
+		if s.Hooks != nil {
+			s.Hooks.Clicked(ctx, data, clicks)
+		}
+	}
+	return s.link(data), nil
+}
+
+func (s *Service) GetStats(ctx context.Context, req *shortenerpb.GetStatsRequest) (*shortenerpb.Stats, error) {
+	key := models.StatsKey{Domain: utils.NormalizeHost(req.GetDomain()), Owner: strings.TrimSpace(req.GetOwner())}
+	stats, err := s.URLs.Stats(ctx, []models.StatsKey{key}, time.Now())
+	if err != nil {
+		return nil, statusError(err, "Unable to count the links")
+	}
+	return &shortenerpb.Stats{
+		Links:       int64(stats[key].Links),
+		Active:      int64(stats[key].Active),
+		Expired:     int64(stats[key].Expired),
+		Deactivated: int64(stats[key].Deactivated),
+		Clicks:      int64(stats[key].Clicks),
+	}, nil
+}
+
+func (s *Service) ListLinks(ctx context.Context, req *shortenerpb.ListLinksRequest) (*shortenerpb.ListLinksResponse, error) {
+	if req.GetLimit() < 0 {
+		return nil, invalidArgument(&h.FieldError{Field: "limit", Code: "invalid_number", Message: "Invalid limit"})
+	}
+	if req.GetOffset() < 0 {
+		return nil, invalidArgument(&h.FieldError{Field: "offset", Code: "invalid_number", Message: "Invalid offset"})
+	}
+	owner := strings.TrimSpace(req.GetOwner())
+	if owner != "" {
+		if err := handler.AuthorizeOwner(ctx, owner); err != nil {
+			return nil, listOwnerError(err)
+		}
+	}
+	list, err := s.URLs.List(ctx, &models.ListFilter{
+		Domain: utils.NormalizeHost(req.GetDomain()),
+		Owner:  owner,
+		Tag:    utils.NormalizeTag(req.GetTag()),
+		Search: strings.TrimSpace(req.GetSearch()),
+		Limit:  min(int(req.GetLimit()), handler.MaxListLimit),
+		Offset: int(req.GetOffset()),
+	})
+	if err != nil {
+		return nil, statusError(err, "Unable to list the links")
+	}
+	response := &shortenerpb.ListLinksResponse{Links: make([]*shortenerpb.Link, 0, len(list))}
+	for _, data := range list {
+		response.Links = append(response.Links, s.link(data))
+	}
+	return response, nil
+}
+
+func (s *Service) Deactivate(ctx context.Context, req *shortenerpb.DeactivateRequest) (*shortenerpb.Link, error) {
+	if err := checkKey(req.GetKey()); err != nil {
+		return nil, err
+	}
+	domain := utils.NormalizeHost(req.GetDomain())
+	if err := handler.AuthorizeLink(ctx, s.URLs, domain, req.GetKey()); err != nil {
+		return nil, authorizeError(err)
+	}
+	data, err := s.URLs.Deactivate(ctx, domain, req.GetKey())
+	if err != nil {
+		return nil, statusError(err, "Unable to deactivate the link")
+	}
+	if s.Hooks != nil {
+		s.Hooks.Notify(ctx, models.EventLinkDeactivated, data)
+	}
+	return s.link(data), nil
+}
+
+// link converts a stored link, its short URL is built like the HTTP API builds it
+// for a request made on Host
+func (s *Service) link(data *models.ShortenerData) *shortenerpb.Link {
+	r := &http.Request{Host: s.Host, Header: http.Header{}}
+	link := &shortenerpb.Link{
+		Domain:       data.Domain,
+		Owner:        data.Owner,
+		Key:          data.ShortenedURLKEY,
+		ShortUrl:     s.Links.ShortURL(r, data.Domain, data.ShortenedURLKEY),
+		OriginalUrl:  data.OriginalURL,
+		Title:        data.Title,
+		Notes:        data.Notes,
+		Tags:         data.Tags,
+		Interstitial: data.Interstitial,
+		Active:       !data.Deactivated,
+		Clicks:       int64(data.Clicks),
+	}
+	if !data.ExpiresAt.IsZero() {
+		link.ExpiresAt = timestamppb.New(data.ExpiresAt)
+	}
+	if !data.Created.IsZero() {
+		link.Created = timestamppb.New(data.Created)
+	}
+	return link
+}
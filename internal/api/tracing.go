@@ -0,0 +1,44 @@
+package api
+
+import (
+	"go-url-shortener/internal/api/handler"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("go-url-shortener/internal/api")
+
+// trace starts the server span of a request, it continues the trace of the
+// client when the request carries a W3C traceparent header
+func (app *App) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(app.links.Proxies.ClientIP(r)),
+			attribute.String("request.id", handler.RequestID(r.Context())),
+		))
+		defer span.End()
+
+		r, info := withRouteInfo(r.WithContext(ctx))
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// the route is only known once the router matched the request
+		if info.route != "" {
+			span.SetName(r.Method + " " + info.route)
+			span.SetAttributes(semconv.HTTPRoute(info.route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
@@ -0,0 +1,130 @@
+// Package auth authenticates the callers of the API by the bearer token of their requests
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/utils"
+	"strings"
+)
+
+// MinTokenLength is the shortest token accepted in the configuration, shorter tokens can be guessed
+const MinTokenLength = 16
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// Admin may call the administration routes: export, import, backups and domains
+	Admin bool
+	// Owner is the owner the caller acts as, it is empty for the administrator
+	Owner string
+}
+
+// ErrUnauthenticated rejects an anonymous caller of an action that needs a token
+var ErrUnauthenticated = errors.New("this action needs an owner token or the admin token")
+
+// ErrForbidden rejects a caller acting on what belongs to another owner
+var ErrForbidden = errors.New("the link belongs to another owner")
+
+// CanManage reports whether the caller may change what belongs to owner, the
+// administrator may change everything and an owner only its own
+func (p Principal) CanManage(owner string) bool {
+	return p.Admin || (p.Owner != "" && p.Owner == owner)
+}
+
+// Tokens are the credentials accepted by the service
+type Tokens struct {
+	admin  string
+	owners []ownerToken
+}
+
+type ownerToken struct {
+	owner, token string
+}
+
+// NewTokens creates the credentials of the service, admin is the token of the
+// administrator and owners maps each owner to its token. The administration routes
+// are closed to everyone when admin is empty
+func NewTokens(admin string, owners map[string]string) (*Tokens, error) {
+	if admin != "" && len(admin) < MinTokenLength {
+		return nil, fmt.Errorf("the admin token must be at least %d characters long", MinTokenLength)
+	}
+	tokens := &Tokens{admin: admin}
+	seen := map[string]bool{admin: admin != ""}
+	for owner, token := range owners {
+		if !utils.IsValidOwner(owner) {
+			return nil, fmt.Errorf("invalid owner %q", owner)
+		}
+		if len(token) < MinTokenLength {
+			return nil, fmt.Errorf("the token of the owner %q must be at least %d characters long", owner, MinTokenLength)
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("the token of the owner %q is already used", owner)
+		}
+		seen[token] = true
+		tokens.owners = append(tokens.owners, ownerToken{owner: owner, token: token})
+	}
+	return tokens, nil
+}
+
+// ParseOwnerTokens parses the comma separated owner=token pairs of the configuration
+func ParseOwnerTokens(s string) (map[string]string, error) {
+	owners := map[string]string{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		owner, token, ok := strings.Cut(field, "=")
+		owner, token = strings.TrimSpace(owner), strings.TrimSpace(token)
+		if !ok || owner == "" || token == "" {
+			// the field is not printed, it may be a token
+			return nil, errors.New("invalid owner token, want owner=token")
+		}
+		if _, ok := owners[owner]; ok {
+			return nil, fmt.Errorf("the owner %q has two tokens", owner)
+		}
+		owners[owner] = token
+	}
+	return owners, nil
+}
+
+// Authenticate returns the caller the token belongs to, it is false for an unknown token
+func (t *Tokens) Authenticate(token string) (Principal, bool) {
+	if t == nil || token == "" {
+		return Principal{}, false
+	}
+	if t.admin != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.admin)) == 1 {
+		return Principal{Admin: true}, true
+	}
+	for _, o := range t.owners {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(o.token)) == 1 {
+			return Principal{Owner: o.owner}, true
+		}
+	}
+	return Principal{}, false
+}
+
+// BearerToken returns the token of an Authorization header of the Bearer scheme
+func BearerToken(header string) (string, bool) {
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type contextKey struct{}
+
+// NewContext stores the authenticated caller of a request
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the caller stored by NewContext, it is false for an anonymous request
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(contextKey{}).(Principal)
+	return principal, ok
+}
@@ -0,0 +1,61 @@
+package auth
+
+import "testing"
+
+func TestAuthenticate(t *testing.T) {
+	tokens, err := NewTokens("admin-token-of-the-tests", map[string]string{"marketing": "marketing-token-of-the-tests"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name, header string
+		admin        bool
+		owner        string
+		ok           bool
+	}{
+		{"Admin token", "Bearer admin-token-of-the-tests", true, "", true},
+		{"Scheme in lower case", "bearer admin-token-of-the-tests", true, "", true},
+		{"Owner token", "Bearer marketing-token-of-the-tests", false, "marketing", true},
+		{"Unknown token", "Bearer admin-token-of-the-test", false, "", false},
+		{"Other scheme", "Basic admin-token-of-the-tests", false, "", false},
+		{"No token", "Bearer ", false, "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			token, _ := BearerToken(tc.header)
+			principal, ok := tokens.Authenticate(token)
+			if ok != tc.ok || principal.Admin != tc.admin || principal.Owner != tc.owner {
+				t.Errorf("got %+v, %v; want admin %v, owner %q, %v", principal, ok, tc.admin, tc.owner, tc.ok)
+			}
+		})
+	}
+
+	if _, err = NewTokens("short", nil); err == nil {
+		t.Error("a short admin token was accepted")
+	}
+	if _, err = NewTokens("admin-token-of-the-tests", map[string]string{"sales": "short"}); err == nil {
+		t.Error("a short owner token was accepted")
+	}
+	if _, err = NewTokens("admin-token-of-the-tests", map[string]string{"sales": "admin-token-of-the-tests"}); err == nil {
+		t.Error("the admin token was accepted as an owner token")
+	}
+	var none *Tokens
+	if _, ok := none.Authenticate("admin-token-of-the-tests"); ok {
+		t.Error("a token was accepted without tokens")
+	}
+}
+
+func TestParseOwnerTokens(t *testing.T) {
+	owners, err := ParseOwnerTokens(" marketing=token-one , sales=token-two,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owners) != 2 || owners["marketing"] != "token-one" || owners["sales"] != "token-two" {
+		t.Errorf("got %v", owners)
+	}
+	for _, s := range []string{"marketing", "=token", "marketing=", "sales=one,sales=two"} {
+		if _, err := ParseOwnerTokens(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package backup takes consistent copies of the SQLite database while it is in use
+// and restores them
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/models"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefix and suffix of the files of a backup directory
+const (
+	filePrefix = "backup-"
+	fileSuffix = ".db"
+	// fileTime sorts the files of a backup directory by when they were taken
+	fileTime = "20060102T150405.000000Z"
+)
+
+// ErrInProgress is returned when a backup is asked for while the directory takes one
+var ErrInProgress = errors.New("a backup is already in progress")
+
+// Info describes a backup file
+type Info struct {
+	Name    string
+	Path    string
+	Size    int64
+	Created time.Time
+}
+
+// Backup writes a consistent copy of the database to path with VACUUM INTO, the
+// database keeps serving reads and writes meanwhile. The copy is written next to
+// path and renamed once complete so path never holds a partial backup
+func Backup(ctx context.Context, db *sql.DB, path string) (*Info, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("backup %s already exists", path)
+	}
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, fmt.Errorf("failed to back up the database: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	return stat(path)
+}
+
+// Validate checks that the file at path is an intact database of this service with
+// the schema version the code expects
+func Validate(ctx context.Context, path string) error {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return validate(ctx, db)
+}
+
+func validate(ctx context.Context, db *sql.DB) error {
+	var result string
+	if err := db.QueryRowContext(ctx, `PRAGMA integrity_check`).Scan(&result); err != nil {
+		return fmt.Errorf("not a readable database: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("the database is corrupted: %s", result)
+	}
+
+	version, err := models.CurrentSchemaVersion(ctx, db)
+	switch {
+	case err != nil:
+		return err
+	case version == 0:
+		return errors.New("not a database of the service: it has no schema version")
+	case version < models.SchemaVersion:
+		return fmt.Errorf("schema version is %d, want %d: apply the pending migrations to the backup first",
+			version, models.SchemaVersion)
+	case version > models.SchemaVersion:
+		return fmt.Errorf("schema version is %d, want %d: the backup was taken by a newer release",
+			version, models.SchemaVersion)
+	}
+	return nil
+}
+
+// Restore replaces the database at dst with the backup at src once the backup was
+// validated. The server must be stopped. The replaced database is kept next to dst
+// and its path returned, it is empty when there was no database at dst
+func Restore(ctx context.Context, src, dst string) (string, error) {
+	srcAbs, err := filepath.Abs(src)
+	if err != nil {
+		return "", err
+	}
+	dstAbs, err := filepath.Abs(dst)
+	if err != nil {
+		return "", err
+	}
+	if srcAbs == dstAbs {
+		return "", errors.New("the backup is the database itself")
+	}
+
+	db, err := openReadOnly(srcAbs)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+	if err = validate(ctx, db); err != nil {
+		return "", fmt.Errorf("invalid backup %s: %w", src, err)
+	}
+
+	// the copy is made in the directory of the database so it can be renamed over it
+	tmp := dstAbs + ".restore"
+	os.Remove(tmp)
+	if _, err = db.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to copy the backup: %w", err)
+	}
+
+	previous := ""
+	if _, err = os.Stat(dstAbs); err == nil {
+		previous = fmt.Sprintf("%s.pre-restore-%s", dst, time.Now().UTC().Format(fileTime))
+		// the journal files move along so the replaced database stays complete
+		for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+			err = os.Rename(dstAbs+suffix, previous+suffix)
+			if err != nil && (suffix == "" || !errors.Is(err, os.ErrNotExist)) {
+				os.Remove(tmp)
+				return "", fmt.Errorf("failed to move the database aside: %w", err)
+			}
+		}
+	}
+	if err = os.Rename(tmp, dstAbs); err != nil {
+		return previous, err
+	}
+	return previous, nil
+}
+
+// openReadOnly opens a database file without creating it when it is missing
+func openReadOnly(path string) (*sql.DB, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = os.Stat(abs); err != nil {
+		return nil, err
+	}
+	uri := &url.URL{Scheme: "file", OmitHost: true, Path: abs, RawQuery: "mode=ro"}
+	db, err := sql.Open("sqlite", uri.String())
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func stat(path string) (*Info, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{Name: fi.Name(), Path: path, Size: fi.Size(), Created: fi.ModTime().UTC()}, nil
+}
+
+// Dir holds the backups of a database, named after when they were taken
+type Dir struct {
+	DB   *sql.DB
+	Path string
+	// Keep is how many backups are kept, the oldest are removed once there are more. Zero keeps them all
+	Keep int
+	// creating is held while a backup is taken, a backup reads the whole database
+	// so they are taken one at a time
+	creating sync.Mutex
+}
+
+// Create takes a backup in the directory and removes the backups beyond Keep, it
+// returns ErrInProgress when another backup is being taken
+func (d *Dir) Create(ctx context.Context) (*Info, error) {
+	if !d.creating.TryLock() {
+		return nil, ErrInProgress
+	}
+	defer d.creating.Unlock()
+	if err := os.MkdirAll(d.Path, 0o755); err != nil {
+		return nil, err
+	}
+	name := filePrefix + time.Now().UTC().Format(fileTime) + fileSuffix
+	info, err := Backup(ctx, d.DB, filepath.Join(d.Path, name))
+	if err != nil {
+		return nil, err
+	}
+	if _, err = d.Prune(); err != nil {
+		return info, fmt.Errorf("failed to remove the old backups: %w", err)
+	}
+	return info, nil
+}
+
+// List returns the backups of the directory, newest first
+func (d *Dir) List() ([]*Info, error) {
+	entries, err := os.ReadDir(d.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	backups := []*Info{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) {
+			continue
+		}
+		info, err := stat(filepath.Join(d.Path, name))
+		if err != nil {
+			return nil, err
+		}
+		if created, err := time.Parse(fileTime, strings.TrimSuffix(strings.TrimPrefix(name, filePrefix), fileSuffix)); err == nil {
+			info.Created = created
+		}
+		backups = append(backups, info)
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Prune removes the oldest backups beyond Keep and returns them
+func (d *Dir) Prune() ([]*Info, error) {
+	backups, err := d.List()
+	if err != nil || d.Keep <= 0 || len(backups) <= d.Keep {
+		return nil, err
+	}
+	removed := backups[d.Keep:]
+	for _, info := range removed {
+		if err = os.Remove(info.Path); err != nil {
+			return nil, err
+		}
+	}
+	return removed, nil
+}
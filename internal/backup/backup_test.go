@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+// newDB creates a database with a table of links at the given schema version
+func newDB(t *testing.T, path string, version int) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE urls (key TEXT PRIMARY KEY, url TEXT);
+		INSERT INTO urls VALUES ('abc', 'https://example.com/');
+		PRAGMA user_version = %d`, version))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func countURLs(t *testing.T, path string) int {
+	t.Helper()
+	db, err := openReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n int
+	if err = db.QueryRow(`SELECT COUNT(*) FROM urls`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "database.db")
+	db := newDB(t, dbPath, models.SchemaVersion)
+
+	backupPath := filepath.Join(dir, "backup.db")
+	info, err := Backup(ctx, db, backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size == 0 || info.Path != backupPath {
+		t.Errorf("got %+v", info)
+	}
+	if _, err = Backup(ctx, db, backupPath); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("got error %v; want an existing backup to be kept", err)
+	}
+
+	// changes after the backup are undone by the restore
+	if _, err = db.Exec(`INSERT INTO urls VALUES ('def', 'https://example.org/')`); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	previous, err := Restore(ctx, backupPath, dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := countURLs(t, dbPath); got != 1 {
+		t.Errorf("got %d links after the restore; want 1", got)
+	}
+	if got := countURLs(t, previous); got != 2 {
+		t.Errorf("got %d links in the replaced database; want 2", got)
+	}
+}
+
+func TestRestoreValidates(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "database.db")
+	newDB(t, dbPath, models.SchemaVersion)
+
+	notSQLite := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(notSQLite, []byte(strings.Repeat("not a database\n", 100)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	older := filepath.Join(dir, "older.db")
+	newDB(t, older, models.SchemaVersion-1)
+	newer := filepath.Join(dir, "newer.db")
+	newDB(t, newer, models.SchemaVersion+1)
+	unversioned := filepath.Join(dir, "unversioned.db")
+	newDB(t, unversioned, 0)
+
+	testCases := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{"Missing backup", filepath.Join(dir, "missing.db"), "no such file"},
+		{"Not a database", notSQLite, "not a readable database"},
+		{"Database of another service", unversioned, "it has no schema version"},
+		{"Older schema", older, "apply the pending migrations"},
+		{"Newer schema", newer, "taken by a newer release"},
+		{"Database itself", dbPath, "the backup is the database itself"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Restore(ctx, tc.src, dbPath)
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("got error %v; want %q", err, tc.wantErr)
+			}
+			// the database is left alone
+			if got := countURLs(t, dbPath); got != 1 {
+				t.Errorf("got %d links; want 1", got)
+			}
+		})
+	}
+}
+
+func TestDirRetention(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	db := newDB(t, filepath.Join(dir, "database.db"), models.SchemaVersion)
+	backups := &Dir{DB: db, Path: filepath.Join(dir, "backups"), Keep: 2}
+
+	names := []string{}
+	for i := 0; i < 3; i++ {
+		info, err := backups.Create(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, info.Name)
+	}
+
+	list, err := backups.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != names[2] || list[1].Name != names[1] {
+		t.Fatalf("got %d backups; want the 2 newest of %v", len(list), names)
+	}
+	if err = Validate(ctx, list[0].Path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDirOneBackupAtATime(t *testing.T) {
+	dir := t.TempDir()
+	db := newDB(t, filepath.Join(dir, "database.db"), models.SchemaVersion)
+	backups := &Dir{DB: db, Path: filepath.Join(dir, "backups")}
+
+	// a backup is being taken
+	backups.creating.Lock()
+	if _, err := backups.Create(context.Background()); !errors.Is(err, ErrInProgress) {
+		t.Errorf("got error %v; want %v", err, ErrInProgress)
+	}
+	backups.creating.Unlock()
+	if _, err := backups.Create(context.Background()); err != nil {
+		t.Errorf("got error %v once the backup was taken", err)
+	}
+}
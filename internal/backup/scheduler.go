@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Scheduler takes a backup in a directory at a fixed interval until it is shut down
+type Scheduler struct {
+	dir      *Dir
+	interval time.Duration
+	logger   *slog.Logger
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewScheduler starts taking a backup every interval, the first one after an interval
+func NewScheduler(dir *Dir, interval time.Duration, logger *slog.Logger) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{dir: dir, interval: interval, logger: logger, ctx: ctx, cancel: cancel}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := s.dir.Create(s.ctx)
+		if err != nil {
+			s.logger.Error("scheduled backup failed", "dir", s.dir.Path, "error", err)
+			continue
+		}
+		s.logger.Info("database backed up", "path", info.Path, "size", info.Size)
+	}
+}
+
+// Shutdown stops the scheduler, a backup in progress is cancelled
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.cancel()
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of a component and of the whole service
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// DefaultTimeout bounds every check so a hung dependency can't hang the probe
+const DefaultTimeout = 2 * time.Second
+
+// ErrDraining fails the readiness while the server is shutting down
+var ErrDraining = errors.New("the server is shutting down")
+
+// Check reports whether a component works, it returns nil when it does
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name     string
+	liveness bool
+	check    Check
+}
+
+// Checker runs the health checks of the service. Liveness only runs the checks
+// that a restart would fix, readiness runs all of them
+type Checker struct {
+	Timeout  time.Duration
+	mu       sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+// New creates a Checker without checks
+func New() *Checker {
+	return &Checker{Timeout: DefaultTimeout}
+}
+
+// AddReadiness adds a check that only fails the readiness, e.g. a database that is down
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.add(namedCheck{name: name, check: check})
+}
+
+// AddLiveness adds a check that fails both the liveness and the readiness
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.add(namedCheck{name: name, liveness: true, check: check})
+}
+
+func (c *Checker) add(check namedCheck) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, check)
+}
+
+// Drain makes the readiness fail from now on so load balancers stop sending requests
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether Drain was called
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Component is the result of one check
+type Component struct {
+	Status string
+	Error  string
+}
+
+// Report is the result of all the checks, it is ok when every component is ok
+type Report struct {
+	Status     string
+	Components map[string]Component
+}
+
+// OK reports whether every component is ok
+func (r *Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// Live runs the liveness checks
+func (c *Checker) Live(ctx context.Context) *Report {
+	return c.run(ctx, true)
+}
+
+// Ready runs every check, it fails while the server is draining
+func (c *Checker) Ready(ctx context.Context) *Report {
+	report := c.run(ctx, false)
+	if c.Draining() {
+		report.Status = StatusFailing
+		report.Components["server"] = Component{Status: StatusFailing, Error: ErrDraining.Error()}
+	} else {
+		report.Components["server"] = Component{Status: StatusOK}
+	}
+	return report
+}
+
+// run runs the checks concurrently, each with the timeout of the Checker
+func (c *Checker) run(ctx context.Context, livenessOnly bool) *Report {
+	c.mu.RLock()
+	checks := make([]namedCheck, 0, len(c.checks))
+	for _, check := range c.checks {
+		if check.liveness || !livenessOnly {
+			checks = append(checks, check)
+		}
+	}
+	c.mu.RUnlock()
+
+	errs := make([]error, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+			defer cancel()
+			errs[i] = check.check(ctx)
+		}()
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusOK, Components: make(map[string]Component, len(checks)+1)}
+	for i, check := range checks {
+		if errs[i] != nil {
+			report.Status = StatusFailing
+			report.Components[check.name] = Component{Status: StatusFailing, Error: errs[i].Error()}
+			continue
+		}
+		report.Components[check.name] = Component{Status: StatusOK}
+	}
+	return report
+}
@@ -0,0 +1,147 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/models"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a whole fetch including redirects
+	DefaultTimeout = 10 * time.Second
+	// MaxBodySize is how much of the page is read, the head of a page is almost always in the first few KB
+	MaxBodySize = 1 << 20
+	// MaxRedirects is the number of redirects followed before giving up
+	MaxRedirects = 5
+)
+
+var (
+	ErrForbiddenAddress = errors.New("destination resolves to a non public address")
+	ErrNotHTML          = errors.New("destination is not an HTML page")
+)
+
+// blockedPrefixes are the special purpose ranges not covered by the netip helpers
+// that a shortener should never contact on behalf of a user
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// Fetcher downloads destination pages and extracts their metadata. It only
+// connects to public addresses, the check is done on the resolved address at
+// dial time so neither redirects nor DNS rebinding can reach internal services.
+type Fetcher struct {
+	client *http.Client
+	// allowPrivate disables the address check, only used by tests against httptest servers
+	allowPrivate bool
+}
+
+// NewFetcher creates a Fetcher with the SSRF protections enabled
+func NewFetcher() *Fetcher {
+	f := &Fetcher{}
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: f.checkAddress,
+	}
+	f.client = &http.Client{
+		Timeout: DefaultTimeout,
+		Transport: &http.Transport{
+			// never go through a proxy from the environment, the proxy would do the dialing for us
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= MaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", MaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// checkAddress is called with the resolved address right before connecting
+func (f *Fetcher) checkAddress(network, address string, _ syscall.RawConn) error {
+	if f.allowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublicAddr(addrPort.Addr()) {
+		return ErrForbiddenAddress
+	}
+	return nil
+}
+
+// IsPublicAddr reports whether ip is a globally routable unicast address
+func IsPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fetch downloads the page at rawURL and extracts its title, description, image and favicon
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*models.PageMetadata, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", "go-url-shortener (link preview)")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("destination returned %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	page := parseHTML(io.LimitReader(resp.Body, MaxBodySize), resp.Request.URL)
+	page.FetchedAt = time.Now().UTC()
+	return page, nil
+}
@@ -0,0 +1,173 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"go-url-shortener/internal/models/mocks"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>  Summer   Sale </title>
+	<meta name="description" content="Plain description">
+	<meta property="og:description" content="Everything is 50% off">
+	<meta property="og:image" content="/images/banner.png">
+	<link rel="shortcut icon" href="https://cdn.example.com/icon.png">
+</head>
+<body><title>Not the title</title></body>
+</html>`
+
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sale", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, testPage)
+	})
+	mux.HandleFunc("/bare", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, `<html><head><meta property="og:title" content="Bare page"></head></html>`)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/sale", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/file.pdf", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func testFetcher() *Fetcher {
+	f := NewFetcher()
+	f.allowPrivate = true
+	return f
+}
+
+func TestFetch(t *testing.T) {
+	ts := newTestServer(t)
+	f := testFetcher()
+
+	page, err := f.Fetch(context.Background(), ts.URL+"/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]string{
+		"title":       {page.Title, "Summer Sale"},
+		"description": {page.Description, "Everything is 50% off"},
+		"image":       {page.ImageURL, ts.URL + "/images/banner.png"},
+		"favicon":     {page.FaviconURL, "https://cdn.example.com/icon.png"},
+	}
+	for name, got := range want {
+		if got[0] != got[1] {
+			t.Errorf("%s: got %q; want %q", name, got[0], got[1])
+		}
+	}
+	if page.FetchedAt.IsZero() {
+		t.Error("FetchedAt is not set")
+	}
+
+	page, err = f.Fetch(context.Background(), ts.URL+"/bare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Bare page" || page.FaviconURL != ts.URL+"/favicon.ico" {
+		t.Errorf("got title %q and favicon %q", page.Title, page.FaviconURL)
+	}
+
+	if _, err = f.Fetch(context.Background(), ts.URL+"/file.pdf"); !errors.Is(err, ErrNotHTML) {
+		t.Errorf("got %v; want %v", err, ErrNotHTML)
+	}
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	ts := newTestServer(t)
+
+	_, err := NewFetcher().Fetch(context.Background(), ts.URL+"/sale")
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("got %v; want %v", err, ErrForbiddenAddress)
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	testCases := map[string]bool{
+		"93.184.215.14":         true,
+		"2606:2800:21f:cb07::1": true,
+		"127.0.0.1":             false,
+		"10.1.2.3":              false,
+		"172.16.0.1":            false,
+		"192.168.1.1":           false,
+		"169.254.169.254":       false,
+		"100.64.0.1":            false,
+		"0.0.0.0":               false,
+		"::1":                   false,
+		"fd00::1":               false,
+		"fe80::1":               false,
+		"::ffff:127.0.0.1":      false,
+	}
+
+	for addr, want := range testCases {
+		t.Run(addr, func(t *testing.T) {
+			if got := IsPublicAddr(netip.MustParseAddr(addr)); got != want {
+				t.Errorf("got %t; want %t", got, want)
+			}
+		})
+	}
+}
+
+func TestQueue(t *testing.T) {
+	ts := newTestServer(t)
+	store := mocks.MockDB()
+	q := NewQueue(testFetcher(), store, 1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	q.Enqueue("", "abcabc1234567890", ts.URL+"/sale")
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	page := store.MockData["abcabc1234567890"].Page
+	if page.Title != "Summer Sale" {
+		t.Errorf("got %q; want %q", page.Title, "Summer Sale")
+	}
+}
+
+func TestQueueCheck(t *testing.T) {
+	// without workers nothing drains the queue
+	q := NewQueue(testFetcher(), mocks.MockDB(), 0, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for i := 0; i < QueueSize; i++ {
+		q.Enqueue("", "abcabc1234567890", "https://example.com/")
+	}
+	if q.Enqueue("", "abcabc1234567890", "https://example.com/") {
+		t.Error("a link was queued in a full queue")
+	}
+	if err := q.Check(context.Background()); err != nil {
+		t.Errorf("a full queue failed the check: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q.Shutdown(ctx)
+	if err := q.Check(context.Background()); err == nil {
+		t.Error("stopped workers passed the check")
+	}
+}
+
+func TestQueueAfterShutdown(t *testing.T) {
+	q := NewQueue(testFetcher(), mocks.MockDB(), 1, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Errorf("second shutdown: %v", err)
+	}
+	if q.Enqueue("", "abcabc1234567890", "https://example.com/") {
+		t.Error("a link was queued after the shutdown")
+	}
+}
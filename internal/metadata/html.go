@@ -0,0 +1,131 @@
+package metadata
+
+import (
+	"go-url-shortener/internal/models"
+	"io"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Max lengths of the stored metadata, pages sometimes stuff whole paragraphs in there
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+// parseHTML reads the head of a page and extracts the metadata, relative URLs are resolved against base
+func parseHTML(r io.Reader, base *url.URL) *models.PageMetadata {
+	page := &models.PageMetadata{}
+	var ogTitle, description, ogDescription, icon string
+	z := html.NewTokenizer(r)
+
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// io.EOF or a broken page, keep whatever was found so far
+			break loop
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.Head {
+				break loop
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				break loop
+			case atom.Title:
+				if page.Title == "" && z.Next() == html.TextToken {
+					page.Title = strings.TrimSpace(string(z.Text()))
+				}
+			case atom.Meta:
+				if !hasAttr {
+					continue
+				}
+				attrs := attributes(z)
+				content := strings.TrimSpace(attrs["content"])
+				switch strings.ToLower(attrs["property"] + attrs["name"]) {
+				case "og:title":
+					ogTitle = content
+				case "og:description":
+					ogDescription = content
+				case "description":
+					description = content
+				case "og:image", "og:image:url":
+					if page.ImageURL == "" {
+						page.ImageURL = resolve(base, content)
+					}
+				}
+			case atom.Link:
+				if !hasAttr {
+					continue
+				}
+				attrs := attributes(z)
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					// prefer the plain icon over apple-touch-icon
+					if rel == "icon" || (rel == "apple-touch-icon" && icon == "") {
+						icon = attrs["href"]
+					}
+				}
+			}
+		}
+	}
+
+	if page.Title == "" {
+		page.Title = ogTitle
+	}
+	page.Description = description
+	if ogDescription != "" {
+		page.Description = ogDescription
+	}
+	page.Title = truncate(page.Title, maxTitleLength)
+	page.Description = truncate(page.Description, maxDescriptionLength)
+
+	// browsers fall back to /favicon.ico when the page doesn't declare an icon
+	if icon == "" {
+		icon = "/favicon.ico"
+	}
+	page.FaviconURL = resolve(base, icon)
+	return page
+}
+
+func attributes(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, value, more := z.TagAttr()
+		attrs[strings.ToLower(string(key))] = string(value)
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// resolve makes ref absolute, only http and https URLs are kept
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
+
+func truncate(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= max {
+		return s
+	}
+	// don't cut a multi byte character in half
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
@@ -0,0 +1,123 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"go-url-shortener/internal/metrics"
+	"go-url-shortener/internal/models"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// QueueSize is the number of links that can wait for their metadata to be fetched
+const QueueSize = 256
+
+type job struct {
+	domain string
+	key    string
+	url    string
+}
+
+// Queue fetches the metadata of new links in the background and stores it with the link
+type Queue struct {
+	fetcher *Fetcher
+	store   models.ShortenerDataInterface
+	logger  *slog.Logger
+	jobs    chan job
+	// mu keeps Shutdown from closing jobs while Enqueue sends to it
+	mu      sync.RWMutex
+	stopped atomic.Bool
+	wg      sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewQueue starts the given number of workers, Shutdown stops them
+func NewQueue(fetcher *Fetcher, store models.ShortenerDataInterface, workers int, logger *slog.Logger) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		fetcher: fetcher,
+		store:   store,
+		logger:  logger,
+		jobs:    make(chan job, QueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue schedules a fetch without blocking the request, the link is skipped when the
+// queue is full or shut down
+func (q *Queue) Enqueue(domain, key, originalURL string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.stopped.Load() {
+		return false
+	}
+	select {
+	case q.jobs <- job{domain: domain, key: key, url: originalURL}:
+		return true
+	default:
+		q.logger.Warn("metadata queue is full, skipping the link", "domain", domain, "key", key)
+		metrics.MetadataSkipped()
+		return false
+	}
+}
+
+// Shutdown stops accepting links and waits for the queued ones to be fetched,
+// when ctx is done first the remaining fetches are cancelled. Only the first call waits
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if q.stopped.Swap(true) {
+		q.mu.Unlock()
+		return nil
+	}
+	close(q.jobs)
+	q.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// Check fails when the workers were shut down. A full queue only skips the metadata
+// of new links, it is counted in the metrics rather than failing the check
+func (q *Queue) Check(ctx context.Context) error {
+	if q.stopped.Load() {
+		return errors.New("the metadata workers are stopped")
+	}
+	return nil
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for j := range q.jobs {
+		if q.ctx.Err() != nil {
+			continue
+		}
+		page, err := q.fetcher.Fetch(q.ctx, j.url)
+		if err != nil {
+			q.logger.Warn("failed to fetch the page metadata", "domain", j.domain, "key", j.key, "error", err)
+			continue
+		}
+		if err = q.store.SetPageMetadata(q.ctx, j.domain, j.key, page); err != nil {
+			q.logger.Error("failed to store the page metadata", "domain", j.domain, "key", j.key, "error", err)
+		}
+	}
+}
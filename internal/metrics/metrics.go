@@ -0,0 +1,246 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "url_shortener"
+
+// Outcomes of a redirect
+const (
+	RedirectHit         = "hit"
+	RedirectNotFound    = "not_found"
+	RedirectInvalidKey  = "invalid_key"
+	RedirectExpired     = "expired"
+	RedirectDeactivated = "deactivated"
+	RedirectPreview     = "preview"
+	RedirectError       = "error"
+)
+
+// Outcomes of a shorten request
+const (
+	ShortenCreated      = "created"
+	ShortenDeduplicated = "deduplicated"
+	ShortenInvalid      = "validation_failed"
+	ShortenError        = "error"
+)
+
+// Registry holds every metric of the service, it is separate from the default
+// registry so only what is registered here is exposed
+var Registry = prometheus.NewRegistry()
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	rpcs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "Number of gRPC calls by method and status code.",
+	}, []string{"method", "code"})
+
+	rpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of the gRPC calls by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	redirects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redirects_total",
+		Help:      "Number of short link lookups by outcome.",
+	}, []string{"outcome"})
+
+	shortens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shorten_total",
+		Help:      "Number of shorten requests by outcome.",
+	}, []string{"outcome"})
+
+	validationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "shorten_validation_failures_total",
+		Help:      "Number of shorten requests rejected by validation, by reason.",
+	}, []string{"reason"})
+
+	keyCollisions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_collisions_total",
+		Help:      "Number of generated keys that were already used and had to be generated again.",
+	})
+
+	keyAttempts = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "key_generation_attempts",
+		Help:      "Number of keys generated until one was unused, per created link.",
+		Buckets:   []float64{1, 2, 3, 4, 5},
+	})
+
+	keyLength = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "key_length",
+		Help:      "Length of the generated keys, it grows when too many of them collide.",
+	})
+
+	keyExhaustions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_generation_exhausted_total",
+		Help:      "Number of links that could not be created because every generated key was already used.",
+	})
+
+	busyRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_busy_retries_total",
+		Help:      "Number of database writes retried because the database was locked by another connection.",
+	})
+
+	metadataSkips = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "metadata_fetches_skipped_total",
+		Help:      "Number of new links whose page metadata wasn't fetched because the queue was full.",
+	})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Latency of the database queries by operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requests,
+		requestDuration,
+		rpcs,
+		rpcDuration,
+		redirects,
+		shortens,
+		validationFailures,
+		keyCollisions,
+		keyAttempts,
+		keyLength,
+		keyExhaustions,
+		busyRetries,
+		metadataSkips,
+		queryDuration,
+	)
+}
+
+// RegisterDB exposes the connection pool statistics of db under the given name
+func RegisterDB(db *sql.DB, name string) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Redirect counts a short link lookup
+func Redirect(outcome string) {
+	redirects.WithLabelValues(outcome).Inc()
+}
+
+// Shorten counts a shorten request
+func Shorten(outcome string) {
+	shortens.WithLabelValues(outcome).Inc()
+}
+
+// ValidationFailure counts a shorten request rejected for the given reason
+func ValidationFailure(reason string) {
+	shortens.WithLabelValues(ShortenInvalid).Inc()
+	validationFailures.WithLabelValues(reason).Inc()
+}
+
+// KeyCollision counts a generated key that was already used
+func KeyCollision() {
+	keyCollisions.Inc()
+}
+
+// KeyAttempts records how many keys were generated until one was unused
+func KeyAttempts(attempts int) {
+	keyAttempts.Observe(float64(attempts))
+}
+
+// KeyLength sets the current length of the generated keys
+func KeyLength(length int) {
+	keyLength.Set(float64(length))
+}
+
+// KeyExhausted counts a link that wasn't created because all its generated keys were used
+func KeyExhausted() {
+	keyExhaustions.Inc()
+}
+
+// BusyRetry counts a database write retried because the database was locked
+func BusyRetry() {
+	busyRetries.Inc()
+}
+
+// MetadataSkipped counts a new link whose page metadata wasn't fetched because the queue was full
+func MetadataSkipped() {
+	metadataSkips.Inc()
+}
+
+// QueryTimer times a database query, call ObserveDuration when the query is done
+//
+//	defer metrics.QueryTimer("get").ObserveDuration()
+func QueryTimer(operation string) *prometheus.Timer {
+	return prometheus.NewTimer(queryDuration.WithLabelValues(operation))
+}
+
+// Instrument counts and times the requests of a route, route is the registered
+// path pattern so keys don't end up in the labels
+func Instrument(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		requests.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Inc()
+		requestDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RPC counts and times a gRPC call, method is the full name of the method and code its status code
+func RPC(method, code string, duration time.Duration) {
+	rpcs.WithLabelValues(method, code).Inc()
+	rpcDuration.WithLabelValues(method).Observe(duration.Seconds())
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
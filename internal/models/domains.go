@@ -0,0 +1,180 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// DefaultDomain is the key namespace of links created on hosts that aren't a registered domain
+const DefaultDomain = ""
+
+var ErrDomainInUse = errors.New("the domain still has links")
+
+type DomainInterface interface {
+	GetDomain(ctx context.Context, host string) (*Domain, error)
+	ListDomains(ctx context.Context) ([]*Domain, error)
+	SaveDomain(ctx context.Context, domain *Domain) error
+	DeleteDomain(ctx context.Context, host string) error
+}
+
+// Domain is a branded short domain with its own key namespace
+type Domain struct {
+	Host string
+	// RootRedirect is where the bare root of the domain redirects to, empty means no redirect
+	RootRedirect string
+	// Owners are allowed to shorten urls on the domain, everyone is allowed when it is empty
+	Owners []string
+}
+
+// AllowsOwner reports whether owner may shorten urls on the domain
+func (d *Domain) AllowsOwner(owner string) bool {
+	if len(d.Owners) == 0 {
+		return true
+	}
+	for _, allowed := range d.Owners {
+		if allowed == owner {
+			return true
+		}
+	}
+	return false
+}
+
+type DomainDBModel struct {
+	DB *sql.DB
+	// ReadDB serves the lookups when it is set, they go to DB otherwise. Every request looks its domain up
+	ReadDB *sql.DB
+	// QueryTimeout bounds every operation, zero leaves them bounded by the context of the caller only
+	QueryTimeout time.Duration
+}
+
+const selectDomains = `SELECT d.host, d.root_redirect,
+	COALESCE((SELECT GROUP_CONCAT(o.owner, ',' ORDER BY o.owner) FROM domain_owners o WHERE o.domain_id = d.domain_id), '')
+	FROM domains d`
+
+// reader is the connection pool the lookups go to
+func (m *DomainDBModel) reader() *sql.DB {
+	if m.ReadDB != nil {
+		return m.ReadDB
+	}
+	return m.DB
+}
+
+// GetDomain retrieves a domain by its host
+func (m *DomainDBModel) GetDomain(ctx context.Context, host string) (*Domain, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "get_domain")
+	defer end()
+	domain, err := scanDomain(m.reader().QueryRowContext(ctx, selectDomains+` WHERE d.host = ?`, host))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return domain, nil
+}
+
+// ListDomains returns every registered domain
+func (m *DomainDBModel) ListDomains(ctx context.Context) ([]*Domain, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "list_domains")
+	defer end()
+	rows, err := m.reader().QueryContext(ctx, selectDomains+` ORDER BY d.host`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []*Domain{}
+	for rows.Next() {
+		domain, err := scanDomain(rows)
+		if err != nil {
+			return nil, err
+		}
+		domains = append(domains, domain)
+	}
+
+	return domains, rows.Err()
+}
+
+func scanDomain(s scanner) (*Domain, error) {
+	domain := &Domain{}
+	var owners string
+	if err := s.Scan(&domain.Host, &domain.RootRedirect, &owners); err != nil {
+		return nil, err
+	}
+	// owners are stored comma separated like tags
+	domain.Owners = splitTags(owners)
+	return domain, nil
+}
+
+// SaveDomain creates the domain or replaces its settings and owners
+func (m *DomainDBModel) SaveDomain(ctx context.Context, domain *Domain) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "save_domain")
+	defer end()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO domains (host, root_redirect) VALUES (?, ?)
+		ON CONFLICT (host) DO UPDATE SET root_redirect = excluded.root_redirect`
+	if _, err = tx.ExecContext(ctx, query, domain.Host, domain.RootRedirect); err != nil {
+		return err
+	}
+	var domainID int64
+	if err = tx.QueryRowContext(ctx, `SELECT domain_id FROM domains WHERE host = ?`, domain.Host).Scan(&domainID); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domain_owners WHERE domain_id = ?`, domainID); err != nil {
+		return err
+	}
+	for _, owner := range domain.Owners {
+		query = `INSERT INTO domain_owners (domain_id, owner) VALUES (?, ?) ON CONFLICT DO NOTHING`
+		if _, err = tx.ExecContext(ctx, query, domainID, owner); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteDomain removes a domain, a domain that still has links can't be deleted
+// as its links would fall back into the default namespace
+func (m *DomainDBModel) DeleteDomain(ctx context.Context, host string) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "delete_domain")
+	defer end()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var links int
+	if err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM urls WHERE domain = ?`, host).Scan(&links); err != nil {
+		return err
+	}
+	if links > 0 {
+		return ErrDomainInUse
+	}
+
+	var domainID int64
+	err = tx.QueryRowContext(ctx, `SELECT domain_id FROM domains WHERE host = ?`, host).Scan(&domainID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	// foreign keys are not enforced by default, remove the owners explicitly
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domain_owners WHERE domain_id = ?`, domainID); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE domain_id = ?`, domainID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
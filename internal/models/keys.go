@@ -0,0 +1,106 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go-url-shortener/internal/metrics"
+	"go-url-shortener/internal/utils"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// keyWindow is how many generated keys the collision rate is measured over
+	keyWindow = 200
+	// keyGrowthRate is the collision rate of a window above which the keys grow by a character
+	keyGrowthRate = 0.01
+)
+
+// KeyExhaustedError is returned when every key generated for a new link was already used
+type KeyExhaustedError struct {
+	Domain   string
+	Attempts int
+	// Length is the length of the last key tried
+	Length int
+}
+
+func (e *KeyExhaustedError) Error() string {
+	return fmt.Sprintf("no unused key on domain %q after %d attempts of up to %d characters", e.Domain, e.Attempts, e.Length)
+}
+
+// KeyGenerator generates the keys of new links. Every retry after a collision tries a key
+// one character longer, and the keys grow for good when more than keyGrowthRate of the
+// keys of a window collided so a crowded key space doesn't keep costing retries
+type KeyGenerator struct {
+	mu        sync.Mutex
+	length    int
+	generated int
+	collided  int
+	// generate returns a random key of the given length, tests replace it
+	generate func(length int) string
+}
+
+// NewKeyGenerator creates a generator of keys starting at length characters
+func NewKeyGenerator(length int) *KeyGenerator {
+	metrics.KeyLength(length)
+	return &KeyGenerator{length: length, generate: utils.GenerateKey}
+}
+
+// defaultKeys is shared by the models created without a generator
+var defaultKeys = NewKeyGenerator(utils.URLKeyLength)
+
+// Length returns the length of the first key tried for a new link
+func (g *KeyGenerator) Length() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.length
+}
+
+// record adds the keys generated for a link to the window and grows the keys
+// when the window collided too often, or right away when no key was found
+func (g *KeyGenerator) record(generated, collided int, exhausted bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.generated += generated
+	g.collided += collided
+	if !exhausted && g.generated < keyWindow {
+		return
+	}
+	if exhausted || float64(g.collided)/float64(g.generated) > keyGrowthRate {
+		g.length = min(g.length+1, utils.MaxURLKeyLength)
+		metrics.KeyLength(g.length)
+	}
+	g.generated, g.collided = 0, 0
+}
+
+// unusedKey generates keys until one isn't used on the domain, within the transaction
+// tx so the key is still unused when it is inserted
+func (g *KeyGenerator) unusedKey(ctx context.Context, tx *sql.Tx, domain string) (string, error) {
+	length := g.Length()
+	for attempt := 1; attempt <= MaxRetry; attempt++ {
+		key := g.generate(min(length+attempt-1, utils.MaxURLKeyLength))
+		// a key named like a route is skipped like a used one
+		used := utils.IsReservedKey(key)
+		if !used {
+			err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM urls WHERE domain = ? AND shortened_url_key = ?)`,
+				domain, key).Scan(&used)
+			if err != nil {
+				return "", err
+			}
+		}
+		if !used {
+			g.record(attempt, attempt-1, false)
+			metrics.KeyAttempts(attempt)
+			trace.SpanFromContext(ctx).SetAttributes(attribute.Int("key.attempts", attempt))
+			return key, nil
+		}
+		keyCollision(ctx)
+	}
+
+	g.record(MaxRetry, MaxRetry, true)
+	metrics.KeyExhausted()
+	return "", &KeyExhaustedError{Domain: domain, Attempts: MaxRetry, Length: min(length+MaxRetry-1, utils.MaxURLKeyLength)}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go-url-shortener/internal/utils"
+	"testing"
+)
+
+func TestKeyGeneratorGrows(t *testing.T) {
+	g := NewKeyGenerator(utils.URLKeyLength)
+
+	// a window below the growth rate keeps the length
+	g.record(keyWindow, 1, false)
+	if got := g.Length(); got != utils.URLKeyLength {
+		t.Errorf("got length %d; want %d", got, utils.URLKeyLength)
+	}
+
+	// the length grows once a window collided too often, not before the window is full
+	g.record(keyWindow/2, keyWindow/2, false)
+	if got := g.Length(); got != utils.URLKeyLength {
+		t.Errorf("got length %d before the window was full; want %d", got, utils.URLKeyLength)
+	}
+	g.record(keyWindow/2, 0, false)
+	if got := g.Length(); got != utils.URLKeyLength+1 {
+		t.Errorf("got length %d; want %d", got, utils.URLKeyLength+1)
+	}
+
+	// an exhausted link grows it right away, up to the longest key
+	g.record(MaxRetry, MaxRetry, true)
+	if got := g.Length(); got != utils.URLKeyLength+2 {
+		t.Errorf("got length %d; want %d", got, utils.URLKeyLength+2)
+	}
+	g = NewKeyGenerator(utils.MaxURLKeyLength)
+	g.record(MaxRetry, MaxRetry, true)
+	if got := g.Length(); got != utils.MaxURLKeyLength {
+		t.Errorf("got length %d; want at most %d", got, utils.MaxURLKeyLength)
+	}
+}
+
+func TestInsertRetriesLongerKeys(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	keys := NewKeyGenerator(4)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read, Keys: keys}
+	ctx := context.Background()
+
+	// the keys of 4 and 5 characters are taken, the third attempt is 6 characters long
+	for _, key := range []string{"aaaa", "aaaaa"} {
+		_, err := m.Import(ctx, []*ShortenerData{{ShortenedURLKEY: key, OriginalURL: "https://example.com/" + key}}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys.generate = func(length int) string { return "aaaaaaaa"[:length] }
+	key, msg, err := m.Insert(ctx, &ShortenerData{OriginalURL: "https://example.com/new"})
+	if err != nil || key != "aaaaaa" || msg != MsgShortened {
+		t.Errorf("got %q, %q, %v; want the key of the third attempt", key, msg, err)
+	}
+
+	// every key is taken
+	keys.generate = func(length int) string { return "aaaa" }
+	_, _, err = m.Insert(ctx, &ShortenerData{OriginalURL: "https://example.com/other"})
+	var exhausted *KeyExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Attempts != MaxRetry {
+		t.Fatalf("got error %v; want a *KeyExhaustedError after %d attempts", err, MaxRetry)
+	}
+	if _, err = m.GetByOriginalURL(ctx, DefaultDomain, "https://example.com/other"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("got error %v; want the link not to be created", err)
+	}
+	if got := keys.Length(); got != 5 {
+		t.Errorf("got length %d; want the keys to grow after an exhausted link", got)
+	}
+}
+
+func TestInsertSkipsReservedKeys(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	keys := NewKeyGenerator(4)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read, Keys: keys}
+
+	generated := []string{"PING", "docs", "abcd"}
+	keys.generate = func(length int) string {
+		key := generated[0]
+		generated = generated[1:]
+		return key
+	}
+	key, _, err := m.Insert(context.Background(), &ShortenerData{OriginalURL: "https://example.com/new"})
+	if err != nil || key != "abcd" {
+		t.Errorf("got %q, %v; want the first key that isn't a route", key, err)
+	}
+}
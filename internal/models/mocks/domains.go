@@ -0,0 +1,48 @@
+package mocks
+
+import (
+	"context"
+	"go-url-shortener/internal/models"
+	"slices"
+	"strings"
+)
+
+type MockDomains struct {
+	MockData map[string]*models.Domain
+	// Links counts the links of each domain, a domain with links can't be deleted
+	Links map[string]int
+}
+
+func (m *MockDomains) GetDomain(ctx context.Context, host string) (*models.Domain, error) {
+	if domain, ok := m.MockData[host]; ok {
+		return domain, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+func (m *MockDomains) ListDomains(ctx context.Context) ([]*models.Domain, error) {
+	domains := []*models.Domain{}
+	for _, domain := range m.MockData {
+		domains = append(domains, domain)
+	}
+	slices.SortFunc(domains, func(a, b *models.Domain) int {
+		return strings.Compare(a.Host, b.Host)
+	})
+	return domains, nil
+}
+
+func (m *MockDomains) SaveDomain(ctx context.Context, domain *models.Domain) error {
+	m.MockData[domain.Host] = domain
+	return nil
+}
+
+func (m *MockDomains) DeleteDomain(ctx context.Context, host string) error {
+	if _, ok := m.MockData[host]; !ok {
+		return models.ErrNotFound
+	}
+	if m.Links[host] > 0 {
+		return models.ErrDomainInUse
+	}
+	delete(m.MockData, host)
+	return nil
+}
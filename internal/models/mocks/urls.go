@@ -1,8 +1,13 @@
 package mocks
 
 import (
+	"context"
 	"errors"
 	"go-url-shortener/internal/models"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
 )
 
 type MockShortenerData struct {
@@ -34,35 +39,172 @@ func MockDB() *MockShortenerData {
 	}
 }
 
-func (m *MockShortenerData) Get(shortened string) (*models.ShortenerData, error) {
-	if data, ok := m.MockData[shortened]; ok {
+// find looks up a link by key or original url in the namespace of the domain
+func (m *MockShortenerData) find(domain, keyOrURL string) (*models.ShortenerData, bool) {
+	data, ok := m.MockData[keyOrURL]
+	if !ok || data.Domain != domain {
+		return nil, false
+	}
+	return data, true
+}
+
+func (m *MockShortenerData) Get(ctx context.Context, domain, shortened string) (*models.ShortenerData, error) {
+	if data, ok := m.find(domain, shortened); ok {
 		return data, nil
 	}
-	return nil, errors.New("shortened URL not found")
+	return nil, models.ErrNotFound
 }
 
-func (m *MockShortenerData) GetByOriginalURL(originalURL string) (*models.ShortenerData, error) {
-	if data, ok := m.MockData[originalURL]; ok {
+func (m *MockShortenerData) GetByOriginalURL(ctx context.Context, domain, originalURL string) (*models.ShortenerData, error) {
+	if data, ok := m.find(domain, originalURL); ok {
 		return data, nil
 	}
-	return nil, errors.New("shortened URL not found")
+	return nil, models.ErrNotFound
 }
 
-func (m *MockShortenerData) IncreaseClicks(shortened string) error {
-	if data, ok := m.MockData[shortened]; ok {
+func (m *MockShortenerData) IncreaseClicks(ctx context.Context, domain, shortened string) (int, error) {
+	if data, ok := m.find(domain, shortened); ok {
 		data.Clicks++
-		return nil
+		return data.Clicks, nil
 	}
-	return errors.New("shortened URL not found")
+	return 0, models.ErrNotFound
 }
 
-func (m *MockShortenerData) Insert(originalURL string, clicks int) (string, string, error) {
-	switch originalURL {
+func (m *MockShortenerData) Deactivate(ctx context.Context, domain, shortened string) (*models.ShortenerData, error) {
+	if data, ok := m.find(domain, shortened); ok {
+		data.Deactivated = true
+		return data, nil
+	}
+	return nil, models.ErrNotFound
+}
+
+func (m *MockShortenerData) Insert(ctx context.Context, data *models.ShortenerData) (string, string, error) {
+	switch data.OriginalURL {
 	case "https://amazon.com/": // a valid case
-		return "abcabc1234567890", "URL successfully shortened", nil
+		return "abcabc1234567890", models.MsgShortened, nil
 	case "https://google.com/": // assume this url is already shoertened
-		return "abcabc1234567890", "URL is already shortened", nil
+		return "abcabc1234567890", models.MsgAlreadyShortened, nil
 	default:
 		return "", "", errors.New("failed to create the shortened URL")
 	}
 }
+
+func (m *MockShortenerData) Update(ctx context.Context, domain, shortened string, update *models.LinkUpdate) (*models.ShortenerData, error) {
+	data, ok := m.find(domain, shortened)
+	if !ok {
+		return nil, models.ErrNotFound
+	}
+	if update.Title != nil {
+		data.Title = *update.Title
+	}
+	if update.Notes != nil {
+		data.Notes = *update.Notes
+	}
+	if update.Tags != nil {
+		data.Tags = *update.Tags
+	}
+	if update.Interstitial != nil {
+		data.Interstitial = *update.Interstitial
+	}
+	if update.ExpiresAt != nil {
+		data.ExpiresAt = *update.ExpiresAt
+	}
+	return data, nil
+}
+
+func (m *MockShortenerData) List(ctx context.Context, filter *models.ListFilter) ([]*models.ShortenerData, error) {
+	links := []*models.ShortenerData{}
+	for key, data := range m.MockData {
+		// the mock data is also keyed by original url, only list each link once
+		if key != data.ShortenedURLKEY || data.Domain != filter.Domain {
+			continue
+		}
+		if filter.Owner != "" && data.Owner != filter.Owner {
+			continue
+		}
+		if filter.Tag != "" && !slices.Contains(data.Tags, filter.Tag) {
+			continue
+		}
+		links = append(links, data)
+	}
+	return links, nil
+}
+
+func (m *MockShortenerData) Stats(ctx context.Context, keys []models.StatsKey, now time.Time) (map[models.StatsKey]*models.LinkStats, error) {
+	stats := make(map[models.StatsKey]*models.LinkStats, len(keys))
+	for _, key := range keys {
+		s := &models.LinkStats{}
+		for k, data := range m.MockData {
+			// the mock data is also keyed by original url, only count each link once
+			if k != data.ShortenedURLKEY || data.Domain != key.Domain || (key.Owner != "" && data.Owner != key.Owner) {
+				continue
+			}
+			s.Links++
+			s.Clicks += data.Clicks
+			switch {
+			case data.Deactivated:
+				s.Deactivated++
+			case data.IsExpired(now):
+				s.Expired++
+			default:
+				s.Active++
+			}
+		}
+		stats[key] = s
+	}
+	return stats, nil
+}
+
+func (m *MockShortenerData) SetPageMetadata(ctx context.Context, domain, shortened string, page *models.PageMetadata) error {
+	if data, ok := m.find(domain, shortened); ok {
+		data.Page = *page
+		return nil
+	}
+	return models.ErrNotFound
+}
+
+func (m *MockShortenerData) Export(ctx context.Context, fn func(*models.ShortenerData) error) error {
+	keys := []string{}
+	for key, data := range m.MockData {
+		// the mock data is also keyed by original url, only export each link once
+		if key == data.ShortenedURLKEY {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if err := fn(m.MockData[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MockShortenerData) Import(ctx context.Context, links []*models.ShortenerData, dryRun bool) (*models.ImportResult, error) {
+	result := &models.ImportResult{Conflicts: []models.ImportConflict{}}
+	imported := map[string]*models.ShortenerData{}
+	for i, data := range links {
+		conflict := models.ImportConflict{Index: i, Domain: data.Domain, Key: data.ShortenedURLKEY, OriginalURL: data.OriginalURL}
+		if existing, ok := m.find(data.Domain, data.OriginalURL); ok {
+			conflict.Existing, conflict.Reason = existing.ShortenedURLKEY, models.ConflictURL
+			if existing.ShortenedURLKEY == data.ShortenedURLKEY {
+				conflict.Reason = models.ConflictExists
+			}
+		} else if existing, ok := m.find(data.Domain, data.ShortenedURLKEY); ok {
+			conflict.Existing, conflict.Reason = existing.OriginalURL, models.ConflictKey
+		}
+		if conflict.Reason != "" {
+			result.Conflicts = append(result.Conflicts, conflict)
+			continue
+		}
+		if data.ShortenedURLKEY == "" {
+			data.ShortenedURLKEY = "abcabc123456" + strconv.Itoa(1000+i)
+		}
+		imported[data.ShortenedURLKEY], imported[data.OriginalURL] = data, data
+		result.Imported++
+	}
+	if !dryRun {
+		maps.Copy(m.MockData, imported)
+	}
+	return result, nil
+}
@@ -0,0 +1,162 @@
+package mocks
+
+import (
+	"context"
+	"go-url-shortener/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MockWebhooks keeps the webhooks and the outbox in memory, it is safe for
+// concurrent use as the dispatcher reads it from its own goroutine
+type MockWebhooks struct {
+	mu         sync.Mutex
+	Webhooks   []*models.Webhook
+	Deliveries []*MockDelivery
+	// Links are scanned for expired links
+	Links *MockShortenerData
+	// notified holds the links whose expiry was announced
+	notified map[string]bool
+}
+
+// MockDelivery is a delivery in the outbox together with its state
+type MockDelivery struct {
+	models.Delivery
+	NextAttempt time.Time
+	Delivered   bool
+	LastError   string
+}
+
+func (m *MockWebhooks) CreateWebhook(ctx context.Context, webhook *models.Webhook) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhook.ID = int64(len(m.Webhooks) + 1)
+	webhook.Created = time.Now().UTC()
+	m.Webhooks = append(m.Webhooks, webhook)
+	return nil
+}
+
+func (m *MockWebhooks) ListWebhooks(ctx context.Context, owner string) ([]*models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhooks := []*models.Webhook{}
+	for _, webhook := range m.Webhooks {
+		if webhook.Owner == owner {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+func (m *MockWebhooks) DeleteWebhook(ctx context.Context, owner string, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, webhook := range m.Webhooks {
+		if webhook.ID == id && webhook.Owner == owner {
+			m.Webhooks = slices.Delete(m.Webhooks, i, i+1)
+			return nil
+		}
+	}
+	return models.ErrNotFound
+}
+
+func (m *MockWebhooks) QueueEvent(ctx context.Context, event *models.WebhookEvent) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queue(event), nil
+}
+
+func (m *MockWebhooks) queue(event *models.WebhookEvent) int {
+	n := 0
+	for _, webhook := range m.Webhooks {
+		if webhook.Owner != event.Owner || (len(webhook.Events) > 0 && !slices.Contains(webhook.Events, event.Event)) {
+			continue
+		}
+		if event.Event == models.EventClickThresholdReached &&
+			(webhook.ClickThreshold == 0 || webhook.ClickThreshold != event.Clicks) {
+			continue
+		}
+		m.Deliveries = append(m.Deliveries, &MockDelivery{
+			Delivery: models.Delivery{
+				ID:        int64(len(m.Deliveries) + 1),
+				WebhookID: webhook.ID,
+				URL:       webhook.URL,
+				Secret:    webhook.Secret,
+				Event:     event.Event,
+				Payload:   event.Payload,
+			},
+			NextAttempt: time.Now(),
+		})
+		n++
+	}
+	return n
+}
+
+func (m *MockWebhooks) QueueExpiredLinks(ctx context.Context, now time.Time, payload func(*models.ShortenerData) ([]byte, error)) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Links == nil {
+		return 0, nil
+	}
+	if m.notified == nil {
+		m.notified = map[string]bool{}
+	}
+	n := 0
+	for key, data := range m.Links.MockData {
+		if key != data.ShortenedURLKEY || data.Owner == "" || data.Deactivated || !data.IsExpired(now) || m.notified[key] {
+			continue
+		}
+		body, err := payload(data)
+		if err != nil {
+			return n, err
+		}
+		m.queue(&models.WebhookEvent{Owner: data.Owner, Event: models.EventLinkExpired, Payload: body})
+		m.notified[key] = true
+		n++
+	}
+	return n, nil
+}
+
+func (m *MockWebhooks) PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]*models.Delivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := []*models.Delivery{}
+	for _, delivery := range m.Deliveries {
+		if len(deliveries) == limit {
+			break
+		}
+		if !delivery.Delivered && !delivery.NextAttempt.IsZero() && !delivery.NextAttempt.After(now) {
+			pending := delivery.Delivery
+			deliveries = append(deliveries, &pending)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *MockWebhooks) MarkDelivered(ctx context.Context, id int64, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery := m.Deliveries[id-1]
+	delivery.Attempts++
+	delivery.Delivered = true
+	delivery.LastError = ""
+	return nil
+}
+
+func (m *MockWebhooks) MarkFailed(ctx context.Context, id int64, attempts int, next time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delivery := m.Deliveries[id-1]
+	delivery.Attempts = attempts
+	delivery.NextAttempt = next
+	delivery.LastError = lastError
+	return nil
+}
+
+// Delivery returns a copy of a delivery to inspect its state
+func (m *MockWebhooks) Delivery(id int64) MockDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.Deliveries[id-1]
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+// SchemaVersion is the version of the database schema the code expects, the version of
+// the last migration in db/migrations, so this has to be bumped with each new one
+const SchemaVersion = 7
+
+// CurrentSchemaVersion returns the schema version of the database
+func CurrentSchemaVersion(ctx context.Context, db *sql.DB) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version)
+	return version, err
+}
+
+// CheckSchema fails when the database is missing migrations the code needs
+func CheckSchema(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		version, err := CurrentSchemaVersion(ctx, db)
+		if err != nil {
+			return err
+		}
+		if version < SchemaVersion {
+			return fmt.Errorf("schema version is %d, want %d: migrations are pending", version, SchemaVersion)
+		}
+		return nil
+	}
+}
+
+// unversionedColumns are the columns added by the migrations from before the schema
+// version was recorded, newest first, they tell how far such a database was migrated
+var unversionedColumns = []struct {
+	column  string
+	version int
+}{
+	{"expires_at", 5},
+	{"domain", 4},
+	{"interstitial", 3},
+	{"page_title", 2},
+	{"title", 1},
+}
+
+// Migrate applies the migrations of files the database is missing, in the order of their
+// names. The version of a migration is its position in that order, counted from 0, and is
+// recorded in PRAGMA user_version once it ran. It returns the names of the applied migrations
+func Migrate(ctx context.Context, db *sql.DB, files fs.FS) ([]string, error) {
+	names, err := fs.Glob(files, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) != SchemaVersion+1 {
+		return nil, fmt.Errorf("found %d migrations, want %d: SchemaVersion is out of date", len(names), SchemaVersion+1)
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	version, err := appliedVersion(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if version >= SchemaVersion {
+		return nil, nil
+	}
+
+	// foreign keys can't be switched off inside a transaction, the rebuild of the urls
+	// table would otherwise delete the tags of every link
+	var foreignKeys bool
+	if err = conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		return nil, err
+	}
+	if foreignKeys {
+		if _, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), `PRAGMA foreign_keys = ON`)
+	}
+
+	applied := []string{}
+	for v := version + 1; v < len(names); v++ {
+		script, err := fs.ReadFile(files, names[v])
+		if err != nil {
+			return applied, err
+		}
+		up, _, _ := strings.Cut(string(script), "-- migrate:down")
+		if err = migrate(ctx, conn, up, v); err != nil {
+			return applied, fmt.Errorf("migration %s: %w", names[v], err)
+		}
+		applied = append(applied, names[v])
+	}
+	return applied, nil
+}
+
+// migrate runs a migration and records its version in one transaction
+func migrate(ctx context.Context, conn *sql.Conn, script string, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedVersion returns the version of the last migration applied to the database, -1 when
+// the database is empty. A database migrated before the version was recorded is at 0 and
+// its version is told from its columns
+func appliedVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	if err := conn.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	if version > 0 {
+		return version, nil
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT name FROM pragma_table_info('urls')`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	columns := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return 0, err
+		}
+		columns[name] = true
+	}
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return -1, nil
+	}
+	for _, c := range unversionedColumns {
+		if columns[c.column] {
+			return c.version, nil
+		}
+	}
+	return 0, nil
+}
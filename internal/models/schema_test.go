@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"go-url-shortener/db/migrations"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Empty database", func(t *testing.T) {
+		db, err := Open(t.TempDir()+"/database.db", DefaultPragmas, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		applied, err := Migrate(ctx, db.Write, migrations.FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != SchemaVersion+1 {
+			t.Errorf("applied %d migrations; want %d", len(applied), SchemaVersion+1)
+		}
+		if err = CheckSchema(db.Read)(ctx); err != nil {
+			t.Error(err)
+		}
+		if applied, err = Migrate(ctx, db.Write, migrations.FS); err != nil || len(applied) != 0 {
+			t.Errorf("applied %v again, error %v; want nothing", applied, err)
+		}
+	})
+
+	t.Run("Database migrated before the version was recorded", func(t *testing.T) {
+		db, err := Open(t.TempDir()+"/database.db", DefaultPragmas, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		for _, name := range []string{"202405191609_create_urls_table.sql", "202610190001_add_link_metadata.sql"} {
+			script, err := migrations.FS.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = db.Write.Exec(string(script)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = db.Write.Exec(`INSERT INTO tags (name) VALUES ('code');
+			INSERT INTO url_tags (url_id, tag_id) SELECT url_id, 1 FROM urls WHERE shortened_url_key = 'K8FN2X9z48U8koUy'`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		applied, err := Migrate(ctx, db.Write, migrations.FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(applied) != SchemaVersion-1 || applied[0] != "202610190002_add_page_metadata.sql" {
+			t.Errorf("applied %v; want every migration after 202610190001_add_link_metadata.sql", applied)
+		}
+		// rebuilding the urls table keeps the tags of the links
+		var tags int
+		if err = db.Read.QueryRow(`SELECT COUNT(*) FROM url_tags`).Scan(&tags); err != nil || tags != 1 {
+			t.Errorf("got %d tags, error %v; want 1", tags, err)
+		}
+		if err = CheckSchema(db.Read)(ctx); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/metrics"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Pragmas tune every connection to the database
+type Pragmas struct {
+	// JournalMode is WAL by default so the readers don't wait for the writer
+	JournalMode string
+	// BusyTimeout is how long a statement waits for a lock held by another connection before failing
+	BusyTimeout time.Duration
+	Synchronous string
+	ForeignKeys bool
+}
+
+// DefaultPragmas suit a server, NORMAL synchronous is durable enough in WAL mode
+var DefaultPragmas = Pragmas{
+	JournalMode: "WAL",
+	BusyTimeout: 5 * time.Second,
+	Synchronous: "NORMAL",
+	ForeignKeys: true,
+}
+
+// JournalModes and SynchronousModes are the accepted values of the pragmas
+var (
+	JournalModes     = []string{"WAL", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "OFF"}
+	SynchronousModes = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+)
+
+// DB is a SQLite database opened as a single writer connection and a pool of
+// reader connections. SQLite only allows one writer at a time, funnelling the
+// writes through one connection queues them in the process instead of failing
+// them with SQLITE_BUSY, while in WAL mode the readers never wait for the writer
+type DB struct {
+	Write *sql.DB
+	Read  *sql.DB
+}
+
+// Open opens the database at path with the pragmas and readConns read connections
+func Open(path string, pragmas Pragmas, readConns int) (*DB, error) {
+	if readConns < 1 {
+		return nil, fmt.Errorf("invalid number of read connections %d", readConns)
+	}
+	params := url.Values{}
+	params.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", pragmas.BusyTimeout.Milliseconds()))
+	params.Add("_pragma", "synchronous("+pragmas.Synchronous+")")
+	params.Add("_pragma", fmt.Sprintf("foreign_keys(%t)", pragmas.ForeignKeys))
+
+	// the writer sets the journal mode, it is stored in the database file. Its transactions
+	// take the write lock when they begin instead of failing to upgrade to it halfway
+	write := url.Values{"_txlock": {"immediate"}}
+	write.Add("_pragma", "journal_mode("+pragmas.JournalMode+")")
+	writer, err := sql.Open("sqlite", dsn(path, params, write))
+	if err != nil {
+		return nil, err
+	}
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	// the writer opens the file first so the readers find it in its journal mode
+	if err = writer.Ping(); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	reader, err := sql.Open("sqlite", dsn(path, params))
+	if err != nil {
+		writer.Close()
+		return nil, err
+	}
+	reader.SetMaxOpenConns(readConns)
+	reader.SetMaxIdleConns(readConns)
+
+	return &DB{Write: writer, Read: reader}, nil
+}
+
+// dsn builds the URI of the database with the query parameters of the driver
+func dsn(path string, params ...url.Values) string {
+	query := url.Values{}
+	for _, p := range params {
+		for name, values := range p {
+			query[name] = append(query[name], values...)
+		}
+	}
+	u := &url.URL{Scheme: "file", OmitHost: true, Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// Ping checks both the writer and the readers can reach the database
+func (db *DB) Ping(ctx context.Context) error {
+	if err := db.Write.PingContext(ctx); err != nil {
+		return err
+	}
+	return db.Read.PingContext(ctx)
+}
+
+// Close closes the readers and the writer
+func (db *DB) Close() error {
+	return errors.Join(db.Read.Close(), db.Write.Close())
+}
+
+// busyRetries is how many times a write is tried again after the busy timeout ran out
+const busyRetries = 3
+
+// isBusy reports whether err is a statement that failed on a lock held by another connection
+func isBusy(err error) bool {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	// the driver reports extended codes such as SQLITE_BUSY_SNAPSHOT
+	code := e.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// retryBusy runs fn and runs it again while it fails on a locked database, such as
+// when another process writes to the same file for longer than the busy timeout
+func retryBusy(ctx context.Context, fn func() error) error {
+	delay := 10 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if attempt == busyRetries || !isBusy(err) {
+			return err
+		}
+		metrics.BusyRetry()
+		trace.SpanFromContext(ctx).AddEvent("database busy")
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTestDB opens a migrated database in a temporary directory
+func openTestDB(t *testing.T, pragmas Pragmas) (*DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "database.db")
+	db, err := Open(path, pragmas, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrations, err := filepath.Glob("../../db/migrations/*.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, migration := range migrations {
+		script, err := os.ReadFile(migration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.Write.Exec(string(script)); err != nil {
+			t.Fatalf("%s: %v", migration, err)
+		}
+	}
+	return db, path
+}
+
+func TestOpenPragmas(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	for _, pool := range []*sql.DB{db.Write, db.Read} {
+		var journalMode string
+		var busyTimeout, synchronous, foreignKeys int
+		pool.QueryRow(`PRAGMA journal_mode`).Scan(&journalMode)
+		pool.QueryRow(`PRAGMA busy_timeout`).Scan(&busyTimeout)
+		pool.QueryRow(`PRAGMA synchronous`).Scan(&synchronous)
+		pool.QueryRow(`PRAGMA foreign_keys`).Scan(&foreignKeys)
+		// synchronous NORMAL is 1
+		if journalMode != "wal" || busyTimeout != 5000 || synchronous != 1 || foreignKeys != 1 {
+			t.Errorf("got journal mode %s, busy timeout %d, synchronous %d, foreign keys %d",
+				journalMode, busyTimeout, synchronous, foreignKeys)
+		}
+	}
+}
+
+// TestConcurrentRedirectsAndShortens hammers the database with redirects and
+// shortens in parallel, none of them may fail on a locked database
+func TestConcurrentRedirectsAndShortens(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+
+	keys := make([]string, 10)
+	for i := range keys {
+		key, _, err := m.Insert(ctx, &ShortenerData{OriginalURL: fmt.Sprintf("https://example.com/%d", i), Tags: []string{"seed"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+	}
+
+	// the migrations seed a few links
+	var seededClicks, seededLinks int
+	if err := db.Read.QueryRow(`SELECT SUM(clicks), COUNT(*) FROM urls`).Scan(&seededClicks, &seededLinks); err != nil {
+		t.Fatal(err)
+	}
+
+	const workers, rounds = 16, 40
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := keys[(w+i)%len(keys)]
+				// a redirect reads the link then counts the click
+				if _, err := m.Get(ctx, DefaultDomain, key); err != nil {
+					errs <- fmt.Errorf("get %s: %w", key, err)
+				}
+				if _, err := m.IncreaseClicks(ctx, DefaultDomain, key); err != nil {
+					errs <- fmt.Errorf("click %s: %w", key, err)
+				}
+				link := &ShortenerData{OriginalURL: fmt.Sprintf("https://example.org/%d/%d", w, i), Tags: []string{"load"}}
+				if _, _, err := m.Insert(ctx, link); err != nil {
+					errs <- fmt.Errorf("shorten: %w", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var clicks, links int
+	if err := db.Read.QueryRow(`SELECT SUM(clicks), COUNT(*) FROM urls`).Scan(&clicks, &links); err != nil {
+		t.Fatal(err)
+	}
+	if clicks-seededClicks != workers*rounds {
+		t.Errorf("got %d clicks; want %d", clicks-seededClicks, workers*rounds)
+	}
+	if links-seededLinks != workers*rounds {
+		t.Errorf("got %d new links; want %d", links-seededLinks, workers*rounds)
+	}
+}
+
+// TestRetryOnBusy holds the write lock from another connection, as a command run
+// next to the server would, for longer than the busy timeout
+func TestRetryOnBusy(t *testing.T) {
+	pragmas := DefaultPragmas
+	pragmas.BusyTimeout = 10 * time.Millisecond
+	db, path := openTestDB(t, pragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+	key, _, err := m.Insert(ctx, &ShortenerData{OriginalURL: "https://example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	lock := func(hold time.Duration) {
+		conn, err := other.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = conn.ExecContext(ctx, `BEGIN IMMEDIATE`); err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(hold, func() {
+			conn.ExecContext(ctx, `ROLLBACK`)
+			conn.Close()
+		})
+	}
+
+	lock(50 * time.Millisecond)
+	if _, err = m.IncreaseClicks(ctx, DefaultDomain, key); err != nil {
+		t.Errorf("got error %v; want the click to be retried until the lock is released", err)
+	}
+
+	lock(time.Second)
+	if _, err = m.IncreaseClicks(ctx, DefaultDomain, key); !isBusy(err) {
+		t.Errorf("got error %v; want the database to be busy once the retries are exhausted", err)
+	}
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"go-url-shortener/internal/metrics"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("go-url-shortener/internal/models")
+
+// startQuery starts the span and the latency timer of a database operation and
+// bounds it with the timeout when it is set, the returned function ends all of them
+func startQuery(ctx context.Context, timeout time.Duration, operation string) (context.Context, func()) {
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	timer := metrics.QueryTimer(operation)
+	ctx, span := tracer.Start(ctx, "db."+operation, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.operation.name", operation),
+		))
+	return ctx, func() {
+		cancel()
+		span.End()
+		timer.ObserveDuration()
+	}
+}
+
+// keyCollision counts a generated key that was already used and notes it on the span
+func keyCollision(ctx context.Context) {
+	metrics.KeyCollision()
+	trace.SpanFromContext(ctx).AddEvent("key collision")
+}
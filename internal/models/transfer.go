@@ -0,0 +1,157 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Reasons an imported link is skipped
+const (
+	ConflictExists = "link already exists"
+	ConflictURL    = "URL is already shortened with another key"
+	ConflictKey    = "key is already used by another URL"
+)
+
+// ImportConflict is a link of an import that was skipped because its key or URL is already used
+type ImportConflict struct {
+	// Index is the position of the link in the import
+	Index       int
+	Domain      string
+	Key         string
+	OriginalURL string
+	Reason      string
+	// Existing is the key already used for the URL, or the URL already using the key
+	Existing string
+}
+
+// ImportResult reports what an import did, or would do for a dry run
+type ImportResult struct {
+	Imported  int
+	Conflicts []ImportConflict
+}
+
+// Export calls fn with every link of every domain, ordered by domain and creation.
+// The links are streamed from a single query so an export is consistent, it is
+// only bounded by the context of the caller as it can take a while
+func (m *ShortenerDBModel) Export(ctx context.Context, fn func(*ShortenerData) error) error {
+	ctx, end := startQuery(ctx, 0, "export")
+	defer end()
+	rows, err := m.reader().QueryContext(ctx, selectURLs+` ORDER BY u.domain, u.url_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		data, err := scanData(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(data); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Import inserts the links in a single transaction, keeping their keys, clicks, state,
+// creation time and page metadata. Links without a key get a generated one, which is set on the link.
+// Links whose key or URL is already used are skipped and reported, any other failure
+// imports nothing. A dry run reports the same result without importing anything
+func (m *ShortenerDBModel) Import(ctx context.Context, links []*ShortenerData, dryRun bool) (*ImportResult, error) {
+	ctx, end := startQuery(ctx, 0, "import")
+	defer end()
+	var result *ImportResult
+	err := retryBusy(ctx, func() error {
+		var err error
+		result, err = m.importLinks(ctx, links, dryRun)
+		return err
+	})
+	return result, err
+}
+
+func (m *ShortenerDBModel) importLinks(ctx context.Context, links []*ShortenerData, dryRun bool) (*ImportResult, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result := &ImportResult{Conflicts: []ImportConflict{}}
+	for i, data := range links {
+		conflict, err := importLink(ctx, tx, m.keys(), data)
+		if err != nil {
+			return nil, fmt.Errorf("link %d: %w", i+1, err)
+		}
+		if conflict != nil {
+			conflict.Index = i
+			result.Conflicts = append(result.Conflicts, *conflict)
+			continue
+		}
+		result.Imported++
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, tx.Commit()
+}
+
+// importLink inserts a link within the transaction of an import unless its key or URL is already used
+func importLink(ctx context.Context, tx *sql.Tx, keys *KeyGenerator, data *ShortenerData) (*ImportConflict, error) {
+	conflict := &ImportConflict{Domain: data.Domain, Key: data.ShortenedURLKEY, OriginalURL: data.OriginalURL}
+	err := tx.QueryRowContext(ctx, `SELECT shortened_url_key FROM urls WHERE domain = ? AND original_url = ?`,
+		data.Domain, data.OriginalURL).Scan(&conflict.Existing)
+	switch {
+	case err == nil && conflict.Existing == data.ShortenedURLKEY:
+		conflict.Reason = ConflictExists
+		return conflict, nil
+	case err == nil:
+		conflict.Reason = ConflictURL
+		return conflict, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	key := data.ShortenedURLKEY
+	if key == "" {
+		if key, err = keys.unusedKey(ctx, tx, data.Domain); err != nil {
+			return nil, err
+		}
+	} else {
+		err = tx.QueryRowContext(ctx, `SELECT original_url FROM urls WHERE domain = ? AND shortened_url_key = ?`,
+			data.Domain, key).Scan(&conflict.Existing)
+		if err == nil {
+			conflict.Reason = ConflictKey
+			return conflict, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	query := `INSERT INTO urls (domain, owner, original_url, shortened_url_key, clicks, active, created, title, notes,
+		interstitial, expires_at, page_title, page_description, page_image_url, page_favicon_url, page_fetched)
+		VALUES (?, ?, ?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, data.Domain, data.Owner, data.OriginalURL, key, data.Clicks, !data.Deactivated,
+		nullTime(data.Created), data.Title, data.Notes, data.Interstitial, nullTime(data.ExpiresAt),
+		data.Page.Title, data.Page.Description, data.Page.ImageURL, data.Page.FaviconURL, nullTime(data.Page.FetchedAt))
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Tags) > 0 {
+		urlID, err := result.LastInsertId()
+		if err != nil {
+			return nil, err
+		}
+		if err = writeTags(ctx, tx, urlID, data.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	data.ShortenedURLKEY = key
+	return nil, nil
+}
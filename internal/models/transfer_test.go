@@ -0,0 +1,32 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestImportKeepsKeysAndPage(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+	fetched := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	page := PageMetadata{Title: "Sale", Description: "Everything must go", ImageURL: "https://example.com/og.png",
+		FaviconURL: "https://example.com/favicon.ico", FetchedAt: fetched}
+	links := []*ShortenerData{
+		{ShortenedURLKEY: "summer-sale_24", OriginalURL: "https://example.com/sale", Clicks: 3, Page: page},
+	}
+	result, err := m.Import(ctx, links, false)
+	if err != nil || result.Imported != 1 {
+		t.Fatalf("got %+v, error %v; want 1 imported link", result, err)
+	}
+
+	data, err := m.Get(ctx, DefaultDomain, "summer-sale_24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Clicks != 3 || data.Page != page {
+		t.Errorf("got %d clicks and page %+v; want 3 clicks and page %+v", data.Clicks, data.Page, page)
+	}
+}
@@ -1,9 +1,10 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"go-url-shortener/internal/utils"
+	"slices"
 	"strings"
 	"time"
 )
@@ -12,88 +13,515 @@ type URLShortener struct {
 	URLs map[string]string
 }
 
+// ShortenerDataInterface stores the links, every domain has its own key namespace
+// so a key is always looked up together with the domain it belongs to
 type ShortenerDataInterface interface {
-	Get(shortened string) (*ShortenerData, error)
-	GetByOriginalURL(originalURL string) (*ShortenerData, error)
-	IncreaseClicks(shortened string) error
-	Insert(original string, clicks int) (string, string, error)
+	Get(ctx context.Context, domain, shortened string) (*ShortenerData, error)
+	GetByOriginalURL(ctx context.Context, domain, originalURL string) (*ShortenerData, error)
+	IncreaseClicks(ctx context.Context, domain, shortened string) (int, error)
+	Insert(ctx context.Context, data *ShortenerData) (string, string, error)
+	Update(ctx context.Context, domain, shortened string, update *LinkUpdate) (*ShortenerData, error)
+	List(ctx context.Context, filter *ListFilter) ([]*ShortenerData, error)
+	SetPageMetadata(ctx context.Context, domain, shortened string, page *PageMetadata) error
+	Deactivate(ctx context.Context, domain, shortened string) (*ShortenerData, error)
+	Export(ctx context.Context, fn func(*ShortenerData) error) error
+	Import(ctx context.Context, links []*ShortenerData, dryRun bool) (*ImportResult, error)
+	Stats(ctx context.Context, keys []StatsKey, now time.Time) (map[StatsKey]*LinkStats, error)
 }
 
 type ShortenerData struct {
+	// Domain is the host of the domain the key belongs to, DefaultDomain for links outside of any domain
+	Domain          string
+	Owner           string
 	OriginalURL     string
 	ShortenedURLKEY string
 	Clicks          int
+	Title           string
+	Notes           string
+	Tags            []string
+	Page            PageMetadata
+	// Interstitial shows a "you are leaving" page before redirecting
+	Interstitial bool
+	// ExpiresAt is when the link stops redirecting, zero means never
+	ExpiresAt time.Time
+	// Deactivated links are kept but don't redirect anymore
+	Deactivated bool
+	Created     time.Time
+	Updated     time.Time
+}
+
+// IsExpired reports whether the link has expired at the given time
+func (d *ShortenerData) IsExpired(now time.Time) bool {
+	return !d.ExpiresAt.IsZero() && !now.Before(d.ExpiresAt)
+}
+
+// PageMetadata is what was found on the destination page, FetchedAt is zero until it was fetched
+type PageMetadata struct {
+	Title       string
+	Description string
+	ImageURL    string
+	FaviconURL  string
+	FetchedAt   time.Time
+}
+
+// LinkUpdate holds the editable fields of a link, a nil field is left untouched
+type LinkUpdate struct {
+	Title        *string
+	Notes        *string
+	Tags         *[]string
+	Interstitial *bool
+	// ExpiresAt sets when the link expires, a zero time removes the expiry
+	ExpiresAt *time.Time
+}
+
+// ListFilter narrows down the links returned by List
+type ListFilter struct {
+	Domain string
+	// Owner only returns the links of this owner when set
+	Owner string
+	// Tag only returns the links carrying this tag
+	Tag string
+	// Search matches the title, notes and original url
+	Search string
+	Limit  int
+	Offset int
+}
+
+// StatsKey selects the links counted by Stats, every link of the domain when Owner is empty
+type StatsKey struct {
+	Domain string
+	Owner  string
+}
+
+// LinkStats counts the links of a StatsKey by status and sums their clicks, a
+// deactivated link is counted as deactivated even once it expired
+type LinkStats struct {
+	Links       int
+	Active      int
+	Expired     int
+	Deactivated int
+	Clicks      int
 }
 
 type ShortenerDBModel struct {
 	DB *sql.DB
+	// ReadDB serves the reads when it is set, they go to DB otherwise
+	ReadDB *sql.DB
+	// Keys generates the keys of new links, a generator shared by the models without one is used when nil
+	Keys *KeyGenerator
+	// QueryTimeout bounds every operation, zero leaves them bounded by the context of the caller only
+	QueryTimeout time.Duration
 }
 
+// MaxRetry is how many keys are generated for a new link before giving up with a *KeyExhaustedError
 const MaxRetry = 5
 
-// Get retrieves a record from the urls table identifying that record by the shortened URL
-func (m *ShortenerDBModel) Get(shortenedKey string) (*ShortenerData, error) {
-	query := `SELECT original_url, shortened_url_key, clicks FROM urls WHERE shortened_url_key = ?`
-	row := m.DB.QueryRow(query, shortenedKey)
+// Messages returned by Insert
+const (
+	MsgShortened        = "URL successfully shortened"
+	MsgAlreadyShortened = "URL is already shortened"
+)
+
+// DefaultListLimit is used when a listing does not ask for a page size
+const DefaultListLimit = 50
+
+var ErrNotFound = errors.New("cannot find the matching record")
+
+// selectURLs selects every column scanned by scanData, tags are folded into a comma separated list
+const selectURLs = `SELECT u.domain, u.owner, u.original_url, u.shortened_url_key, u.clicks, u.title, u.notes,
+	u.page_title, u.page_description, u.page_image_url, u.page_favicon_url, u.page_fetched, u.interstitial, u.expires_at,
+	COALESCE(u.active, TRUE) = FALSE, u.created, u.updated,
+	COALESCE((SELECT GROUP_CONCAT(t.name, ',' ORDER BY t.name) FROM url_tags ut JOIN tags t ON t.tag_id = ut.tag_id WHERE ut.url_id = u.url_id), '')
+	FROM urls u`
+
+// keys is the generator of the keys of new links
+func (m *ShortenerDBModel) keys() *KeyGenerator {
+	if m.Keys != nil {
+		return m.Keys
+	}
+	return defaultKeys
+}
+
+// reader is the connection pool the reads go to
+func (m *ShortenerDBModel) reader() *sql.DB {
+	if m.ReadDB != nil {
+		return m.ReadDB
+	}
+	return m.DB
+}
+
+// Get retrieves a record from the urls table identifying that record by the domain and shortened URL
+func (m *ShortenerDBModel) Get(ctx context.Context, domain, shortenedKey string) (*ShortenerData, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "get")
+	defer end()
+	query := selectURLs + ` WHERE u.domain = ? AND u.shortened_url_key = ?`
+	row := m.reader().QueryRowContext(ctx, query, domain, shortenedKey)
 	return get(row)
 }
 
-func (m *ShortenerDBModel) GetByOriginalURL(originalURL string) (*ShortenerData, error) {
-	query := `SELECT original_url, shortened_url_key, clicks FROM urls WHERE original_url = ?`
-	row := m.DB.QueryRow(query, originalURL)
+func (m *ShortenerDBModel) GetByOriginalURL(ctx context.Context, domain, originalURL string) (*ShortenerData, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "get_by_original_url")
+	defer end()
+	query := selectURLs + ` WHERE u.domain = ? AND u.original_url = ?`
+	row := m.reader().QueryRowContext(ctx, query, domain, originalURL)
 	return get(row)
 }
 
 func get(r *sql.Row) (*ShortenerData, error) {
-	data := &ShortenerData{}
-	err := r.Scan(&data.OriginalURL, &data.ShortenedURLKEY, &data.Clicks)
+	data, err := scanData(r)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("cannot find the matching record")
+			return nil, ErrNotFound
 		}
 		return nil, err
 	}
 	return data, nil
 }
 
-// IncreaseClicks increase the clicks number of a given key by one
-func (m *ShortenerDBModel) IncreaseClicks(shortenedKey string) error {
-	query := `UPDATE urls SET clicks = clicks + 1 WHERE shortened_url_key = ?`
-	_, err := m.DB.Exec(query, shortenedKey)
+// scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanData(s scanner) (*ShortenerData, error) {
+	data := &ShortenerData{}
+	var tags string
+	var fetched, expires, created, updated sql.NullTime
+	err := s.Scan(&data.Domain, &data.Owner, &data.OriginalURL, &data.ShortenedURLKEY, &data.Clicks, &data.Title, &data.Notes,
+		&data.Page.Title, &data.Page.Description, &data.Page.ImageURL, &data.Page.FaviconURL, &fetched, &data.Interstitial,
+		&expires, &data.Deactivated, &created, &updated, &tags)
+	if err != nil {
+		return nil, err
+	}
+	data.Tags = splitTags(tags)
+	data.Page.FetchedAt = fetched.Time
+	data.ExpiresAt = expires.Time
+	data.Created = created.Time
+	data.Updated = updated.Time
+	return data, nil
+}
+
+// nullTime stores a zero time as NULL, times are stored in UTC so they compare as text
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func splitTags(tags string) []string {
+	if tags == "" {
+		return []string{}
+	}
+	return strings.Split(tags, ",")
+}
+
+// IncreaseClicks increase the clicks number of a given key by one and returns the new number
+func (m *ShortenerDBModel) IncreaseClicks(ctx context.Context, domain, shortenedKey string) (int, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "increase_clicks")
+	defer end()
+	var clicks int
+	query := `UPDATE urls SET clicks = clicks + 1 WHERE domain = ? AND shortened_url_key = ? RETURNING clicks`
+	err := retryBusy(ctx, func() error {
+		return m.DB.QueryRowContext(ctx, query, domain, shortenedKey).Scan(&clicks)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return clicks, nil
+}
+
+// Deactivate soft deletes a link, it is kept with its clicks but doesn't redirect anymore
+func (m *ShortenerDBModel) Deactivate(ctx context.Context, domain, shortenedKey string) (*ShortenerData, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "deactivate")
+	defer end()
+	query := `UPDATE urls SET active = FALSE WHERE domain = ? AND shortened_url_key = ?`
+	result, err := m.exec(ctx, query, domain, shortenedKey)
 	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return nil, ErrNotFound
+	}
+
+	return m.Get(ctx, domain, shortenedKey)
+}
+
+// Insert inserts a new record into the urls table and returns its generated key, or the
+// key of the link that already shortens the same URL on the domain with MsgAlreadyShortened.
+// The lookup, the insert and the tags run in one transaction so two concurrent submissions
+// of the same URL both get the same key instead of both inserting it
+func (m *ShortenerDBModel) Insert(ctx context.Context, data *ShortenerData) (string, string, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "insert")
+	defer end()
+	var shortenedKey, msg string
+	err := retryBusy(ctx, func() error {
+		var err error
+		shortenedKey, msg, err = m.insert(ctx, data)
 		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return shortenedKey, msg, nil
+}
+
+func (m *ShortenerDBModel) insert(ctx context.Context, data *ShortenerData) (string, string, error) {
+	// the writer begins its transactions immediately, so the lookup already holds the write lock
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	existing := `SELECT shortened_url_key FROM urls WHERE domain = ? AND original_url = ?`
+	var shortenedKey string
+	err = tx.QueryRowContext(ctx, existing, data.Domain, data.OriginalURL).Scan(&shortenedKey)
+	if err == nil {
+		return shortenedKey, MsgAlreadyShortened, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", "", err
+	}
+
+	if shortenedKey, err = m.keys().unusedKey(ctx, tx, data.Domain); err != nil {
+		return "", "", err
+	}
+	// on a connection whose transactions begin deferred another writer may insert the URL
+	// between the lookup and the insert, the conflict then answers with its key
+	query := `INSERT INTO urls (domain, owner, original_url, shortened_url_key, clicks, title, notes, interstitial, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT (domain, original_url) DO NOTHING`
+	result, err := tx.ExecContext(ctx, query, data.Domain, data.Owner, data.OriginalURL, shortenedKey, data.Clicks, data.Title,
+		data.Notes, data.Interstitial, nullTime(data.ExpiresAt))
+	if err != nil {
+		return "", "", err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = tx.QueryRowContext(ctx, existing, data.Domain, data.OriginalURL).Scan(&shortenedKey)
+		}
+		if err != nil {
+			return "", "", err
+		}
+		return shortenedKey, MsgAlreadyShortened, nil
+	}
+
+	if len(data.Tags) > 0 {
+		urlID, err := result.LastInsertId()
+		if err != nil {
+			return "", "", err
+		}
+		if err = writeTags(ctx, tx, urlID, data.Tags); err != nil {
+			return "", "", err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return shortenedKey, MsgShortened, nil
+}
+
+// Update changes the title, notes, tags, interstitial flag and expiry of a link and returns
+// the updated link, the changes are written in one transaction so they apply together or not at all
+func (m *ShortenerDBModel) Update(ctx context.Context, domain, shortenedKey string, update *LinkUpdate) (*ShortenerData, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "update")
+	defer end()
+	err := retryBusy(ctx, func() error {
+		return m.update(ctx, domain, shortenedKey, update)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Get(ctx, domain, shortenedKey)
+}
+
+func (m *ShortenerDBModel) update(ctx context.Context, domain, shortenedKey string, update *LinkUpdate) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var urlID int64
+	query := `SELECT url_id FROM urls WHERE domain = ? AND shortened_url_key = ?`
+	if err = tx.QueryRowContext(ctx, query, domain, shortenedKey).Scan(&urlID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	// a nil field is passed as NULL and keeps the current value
+	query = `UPDATE urls SET title = COALESCE(?, title), notes = COALESCE(?, notes), interstitial = COALESCE(?, interstitial)
+		WHERE url_id = ?`
+	if _, err = tx.ExecContext(ctx, query, update.Title, update.Notes, update.Interstitial, urlID); err != nil {
+		return err
+	}
+	if update.ExpiresAt != nil {
+		// a new expiry is announced again when it is reached
+		query = `UPDATE urls SET expires_at = ?, expiry_notified = FALSE WHERE url_id = ?`
+		if _, err = tx.ExecContext(ctx, query, nullTime(*update.ExpiresAt), urlID); err != nil {
+			return err
+		}
+	}
+	if update.Tags != nil {
+		if err = writeTags(ctx, tx, urlID, *update.Tags); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetPageMetadata stores the metadata fetched from the destination page of a link
+func (m *ShortenerDBModel) SetPageMetadata(ctx context.Context, domain, shortenedKey string, page *PageMetadata) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "set_page_metadata")
+	defer end()
+	query := `UPDATE urls SET page_title = ?, page_description = ?, page_image_url = ?, page_favicon_url = ?, page_fetched = ?
+		WHERE domain = ? AND shortened_url_key = ?`
+	result, err := m.exec(ctx, query, page.Title, page.Description, page.ImageURL, page.FaviconURL, page.FetchedAt,
+		domain, shortenedKey)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
 	}
 
 	return nil
 }
 
-// Insert inserts a new record into the urls table
-// Need to returns 3 arguments shortenedURLKey, responseMessage and error to handle cases like
-// case 1: original url is already shortened, return the shortened url key
-// case 2: generated key is already used for another url, retry the key generation for a max 5 times
-func (m *ShortenerDBModel) Insert(originalURL string, clicks int) (string, string, error) {
-	var shortenedKey string
-	query := `INSERT INTO urls  (original_url, shortened_url_key, clicks) VALUES(?, ?, ?)`
-	// retry for max 5 times to avoid same shortened key though the chance of that happening
-	// is very low as we use 16-digits number and letter combinations
-	for i := 0; i < MaxRetry; i++ {
-		// generate a unique key and save it in db
-		shortenedKey = utils.GenerateShortURLKey()
-		_, err := m.DB.Exec(query, originalURL, shortenedKey, clicks)
+// exec runs a write, it is retried while the database is locked by another connection
+func (m *ShortenerDBModel) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	var result sql.Result
+	err := retryBusy(ctx, func() error {
+		var err error
+		result, err = m.DB.ExecContext(ctx, query, args...)
+		return err
+	})
+	return result, err
+}
+
+// writeTags replaces the tags of a url within the transaction tx
+func writeTags(ctx context.Context, tx *sql.Tx, urlID int64, tags []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM url_tags WHERE url_id = ?`, urlID); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING`, tag); err != nil {
+			return err
+		}
+		query := `INSERT INTO url_tags (url_id, tag_id) SELECT ?, tag_id FROM tags WHERE name = ? ON CONFLICT DO NOTHING`
+		if _, err := tx.ExecContext(ctx, query, urlID, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern, searching "50%" finds the text 50%
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// List returns the links of a domain matching the filter, newest first
+func (m *ShortenerDBModel) List(ctx context.Context, filter *ListFilter) ([]*ShortenerData, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "list")
+	defer end()
+	conditions := []string{`u.domain = ?`}
+	args := []any{filter.Domain}
+	if filter.Owner != "" {
+		conditions = append(conditions, `u.owner = ?`)
+		args = append(args, filter.Owner)
+	}
+	if filter.Tag != "" {
+		conditions = append(conditions, `u.url_id IN (SELECT ut.url_id FROM url_tags ut JOIN tags t ON t.tag_id = ut.tag_id WHERE t.name = ?)`)
+		args = append(args, filter.Tag)
+	}
+	if filter.Search != "" {
+		conditions = append(conditions, `(u.title LIKE ? ESCAPE '\' OR u.notes LIKE ? ESCAPE '\' OR u.original_url LIKE ? ESCAPE '\')`)
+		pattern := "%" + likeEscaper.Replace(filter.Search) + "%"
+		args = append(args, pattern, pattern, pattern)
+	}
+
+	query := selectURLs + ` WHERE ` + strings.Join(conditions, " AND ")
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	query += ` ORDER BY u.url_id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, filter.Offset)
+
+	rows, err := m.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []*ShortenerData{}
+	for rows.Next() {
+		data, err := scanData(rows)
 		if err != nil {
-			// TODO find a better way to handle duplicate keys
-			if strings.Contains(err.Error(), "UNIQUE constraint failed: urls.original_url") {
-				data, _ := m.GetByOriginalURL(originalURL)
-				return data.ShortenedURLKEY, "URL is already shortened", nil
+			return nil, err
+		}
+		links = append(links, data)
+	}
+
+	return links, rows.Err()
+}
+
+// Stats counts the links of every key in a single query, whichever the number of
+// keys, so callers can batch the aggregates they need. A key without links gets
+// zero stats, links expired at now are counted as expired
+func (m *ShortenerDBModel) Stats(ctx context.Context, keys []StatsKey, now time.Time) (map[StatsKey]*LinkStats, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "stats")
+	defer end()
+	stats := make(map[StatsKey]*LinkStats, len(keys))
+	domains := []string{}
+	args := []any{now.UTC()}
+	for _, key := range keys {
+		stats[key] = &LinkStats{}
+		if !slices.Contains(domains, key.Domain) {
+			domains = append(domains, key.Domain)
+			args = append(args, key.Domain)
+		}
+	}
+	if len(domains) == 0 {
+		return stats, nil
+	}
+
+	query := `SELECT domain, owner, COUNT(*),
+		SUM(COALESCE(active, TRUE) = FALSE),
+		SUM(COALESCE(active, TRUE) AND expires_at IS NOT NULL AND expires_at <= ?),
+		SUM(clicks)
+		FROM urls WHERE domain IN (?` + strings.Repeat(", ?", len(domains)-1) + `) GROUP BY domain, owner`
+	rows, err := m.reader().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var domain, owner string
+		var links, deactivated, expired, clicks int
+		if err := rows.Scan(&domain, &owner, &links, &deactivated, &expired, &clicks); err != nil {
+			return nil, err
+		}
+		// the rows of an owner add up to the stats of the owner and of the whole domain
+		for _, key := range []StatsKey{{Domain: domain, Owner: owner}, {Domain: domain}} {
+			if s, ok := stats[key]; ok {
+				s.Links += links
+				s.Deactivated += deactivated
+				s.Expired += expired
+				s.Active += links - deactivated - expired
+				s.Clicks += clicks
 			}
-			if strings.Contains(err.Error(), "UNIQUE constraint failed: urls.shortened_url_key") {
-				// small time delay to avoid tight loop
-				time.Sleep(100 * time.Millisecond)
-				continue
+			if owner == "" {
+				// both keys are the whole domain for the links without owner
+				break
 			}
-			return "", "", err
 		}
 	}
-
-	return shortenedKey, "URL successfully shortened", nil
+	return stats, rows.Err()
 }
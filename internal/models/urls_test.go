@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestConcurrentInsertSameURL submits the same URL from many goroutines at once, run
+// it with -race: every submission must get the same key and the URL is stored once
+func TestConcurrentInsertSameURL(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+
+	const submissions = 32
+	keys := make([]string, submissions)
+	msgs := make([]string, submissions)
+	errs := make([]error, submissions)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < submissions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			keys[i], msgs[i], errs[i] = m.Insert(ctx, &ShortenerData{OriginalURL: "https://example.com/launch", Tags: []string{"launch"}})
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for i := range keys {
+		if errs[i] != nil {
+			t.Fatalf("submission %d: %v", i, errs[i])
+		}
+		if keys[i] != keys[0] {
+			t.Errorf("submission %d got key %s; want %s", i, keys[i], keys[0])
+		}
+		if msgs[i] == MsgShortened {
+			created++
+		}
+	}
+	if created != 1 {
+		t.Errorf("got %d submissions creating the link; want 1", created)
+	}
+
+	var rows int
+	err := db.Read.QueryRow(`SELECT COUNT(*) FROM urls WHERE original_url = ?`, "https://example.com/launch").Scan(&rows)
+	if err != nil || rows != 1 {
+		t.Errorf("got %d rows, error %v; want 1", rows, err)
+	}
+	data, err := m.Get(ctx, DefaultDomain, keys[0])
+	if err != nil || len(data.Tags) != 1 {
+		t.Errorf("got %+v, error %v; want the link with its tag", data, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+	now := time.Now()
+
+	links := []*ShortenerData{
+		{OriginalURL: "https://example.com/1", Owner: "alice", Clicks: 3},
+		{OriginalURL: "https://example.com/2", Owner: "alice", ExpiresAt: now.Add(-time.Hour)},
+		{OriginalURL: "https://example.com/3", Owner: "bob", Clicks: 4, Deactivated: true},
+	}
+	if _, err := m.Import(ctx, links, false); err != nil {
+		t.Fatal(err)
+	}
+
+	domain, alice, bob := StatsKey{Domain: DefaultDomain}, StatsKey{Domain: DefaultDomain, Owner: "alice"}, StatsKey{Domain: DefaultDomain, Owner: "bob"}
+	other := StatsKey{Domain: "go.example.com"}
+	stats, err := m.Stats(ctx, []StatsKey{domain, alice, bob, other}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the migrations seed 3 links with 600 clicks, one of them deactivated
+	want := map[StatsKey]LinkStats{
+		domain: {Links: 6, Active: 3, Expired: 1, Deactivated: 2, Clicks: 607},
+		alice:  {Links: 2, Active: 1, Expired: 1, Clicks: 3},
+		bob:    {Links: 1, Deactivated: 1, Clicks: 4},
+		other:  {},
+	}
+	for key, w := range want {
+		if got := stats[key]; got == nil || *got != w {
+			t.Errorf("%+v: got %+v; want %+v", key, got, w)
+		}
+	}
+}
+
+func TestUpdateIsAtomic(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+
+	key, _, err := m.Insert(ctx, &ShortenerData{OriginalURL: "https://example.com/sale", Title: "Sale"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	title, tags := "Summer sale", []string{"summer"}
+	update := &LinkUpdate{Title: &title, Tags: &tags}
+
+	// the tags fail after the title was written, the title must be rolled back with them
+	if _, err = db.Write.Exec(`CREATE TEMP TRIGGER fail_tags BEFORE INSERT ON url_tags BEGIN SELECT RAISE(ABORT, 'no tags'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Update(ctx, DefaultDomain, key, update); err == nil {
+		t.Fatal("the update succeeded without its tags")
+	}
+	if data, err := m.Get(ctx, DefaultDomain, key); err != nil || data.Title != "Sale" {
+		t.Errorf("got %+v, error %v; want the title unchanged", data, err)
+	}
+
+	if _, err = db.Write.Exec(`DROP TRIGGER temp.fail_tags`); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Update(ctx, DefaultDomain, key, update)
+	if err != nil || data.Title != title || len(data.Tags) != 1 {
+		t.Errorf("got %+v, error %v; want the new title and tags", data, err)
+	}
+	if _, err = m.Update(ctx, DefaultDomain, "abcabc0000000000", update); err != ErrNotFound {
+		t.Errorf("got %v; want %v", err, ErrNotFound)
+	}
+}
+
+func TestListSearchMatchesWildcardsLiterally(t *testing.T) {
+	db, _ := openTestDB(t, DefaultPragmas)
+	m := &ShortenerDBModel{DB: db.Write, ReadDB: db.Read}
+	ctx := context.Background()
+
+	links := []*ShortenerData{
+		{OriginalURL: "https://example.com/1", Title: "50% off"},
+		{OriginalURL: "https://example.com/2", Title: "500 offers"},
+		{OriginalURL: "https://example.com/3", Title: "snake_case"},
+		{OriginalURL: "https://example.com/4", Title: "snakescase"},
+		{OriginalURL: "https://example.com/5", Title: `back\slash`},
+	}
+	if _, err := m.Import(ctx, links, false); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]string{
+		"50%": "50% off",
+		"e_c": "snake_case",
+		`k\s`: `back\slash`,
+	}
+	for search, want := range testCases {
+		t.Run(search, func(t *testing.T) {
+			found, err := m.List(ctx, &ListFilter{Domain: DefaultDomain, Search: search})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(found) != 1 || found[0].Title != want {
+				t.Errorf("got %d links; want only %q", len(found), want)
+			}
+		})
+	}
+}
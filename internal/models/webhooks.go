@@ -0,0 +1,290 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Events sent to webhooks
+const (
+	EventLinkCreated           = "link.created"
+	EventLinkUpdated           = "link.updated"
+	EventLinkDeactivated       = "link.deactivated"
+	EventLinkExpired           = "link.expired"
+	EventClickThresholdReached = "link.click_threshold_reached"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to
+var WebhookEvents = []string{
+	EventLinkCreated,
+	EventLinkUpdated,
+	EventLinkDeactivated,
+	EventLinkExpired,
+	EventClickThresholdReached,
+}
+
+type WebhookInterface interface {
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	ListWebhooks(ctx context.Context, owner string) ([]*Webhook, error)
+	DeleteWebhook(ctx context.Context, owner string, id int64) error
+	QueueEvent(ctx context.Context, event *WebhookEvent) (int, error)
+	QueueExpiredLinks(ctx context.Context, now time.Time, payload func(*ShortenerData) ([]byte, error)) (int, error)
+	PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]*Delivery, error)
+	MarkDelivered(ctx context.Context, id int64, at time.Time) error
+	MarkFailed(ctx context.Context, id int64, attempts int, next time.Time, lastError string) error
+}
+
+// Webhook is an endpoint of an owner that receives the events of the owner's links
+type Webhook struct {
+	ID    int64
+	Owner string
+	URL   string
+	// Secret signs the payloads so the receiver can check they come from us
+	Secret string
+	// Events are the events sent to the webhook, every event when it is empty
+	Events []string
+	// ClickThreshold sends EventClickThresholdReached when a link reaches this many clicks, 0 disables it
+	ClickThreshold int
+	Created        time.Time
+}
+
+// WebhookEvent is an event of a link of Owner, it is queued for every webhook of the owner subscribed to it
+type WebhookEvent struct {
+	Owner string
+	Event string
+	// Clicks is the number of clicks that was reached, only used by EventClickThresholdReached
+	Clicks  int
+	Payload []byte
+}
+
+// Delivery is an event waiting in the outbox to be sent to a webhook
+type Delivery struct {
+	ID        int64
+	WebhookID int64
+	URL       string
+	Secret    string
+	Event     string
+	Payload   []byte
+	Attempts  int
+}
+
+type WebhookDBModel struct {
+	DB *sql.DB
+	// QueryTimeout bounds every operation, zero leaves them bounded by the context of the caller only
+	QueryTimeout time.Duration
+}
+
+// CreateWebhook registers a webhook and sets its ID
+func (m *WebhookDBModel) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "create_webhook")
+	defer end()
+	query := `INSERT INTO webhooks (owner, url, secret, events, click_threshold, created) VALUES (?, ?, ?, ?, ?, ?)`
+	webhook.Created = time.Now().UTC()
+	result, err := m.DB.ExecContext(ctx, query, webhook.Owner, webhook.URL, webhook.Secret,
+		strings.Join(webhook.Events, ","), webhook.ClickThreshold, webhook.Created)
+	if err != nil {
+		return err
+	}
+	webhook.ID, err = result.LastInsertId()
+	return err
+}
+
+// ListWebhooks returns the webhooks of an owner, oldest first
+func (m *WebhookDBModel) ListWebhooks(ctx context.Context, owner string) ([]*Webhook, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "list_webhooks")
+	defer end()
+	query := `SELECT webhook_id, owner, url, secret, events, click_threshold, created FROM webhooks
+		WHERE owner = ? ORDER BY webhook_id`
+	rows, err := m.DB.QueryContext(ctx, query, owner)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook := &Webhook{}
+		var events string
+		err = rows.Scan(&webhook.ID, &webhook.Owner, &webhook.URL, &webhook.Secret, &events, &webhook.ClickThreshold,
+			&webhook.Created)
+		if err != nil {
+			return nil, err
+		}
+		webhook.Events = splitTags(events)
+		webhooks = append(webhooks, webhook)
+	}
+
+	return webhooks, rows.Err()
+}
+
+// DeleteWebhook removes a webhook of an owner together with its pending deliveries
+func (m *WebhookDBModel) DeleteWebhook(ctx context.Context, owner string, id int64) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "delete_webhook")
+	defer end()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM webhooks WHERE webhook_id = ? AND owner = ?`, id, owner)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	// ON DELETE CASCADE already removed the deliveries, unless foreign keys were turned
+	// off with the foreign_keys pragma, they are removed explicitly for that case
+	if _, err = tx.ExecContext(ctx, `DELETE FROM webhook_outbox WHERE webhook_id = ?`, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// queueEvent is shared by QueueEvent and QueueExpiredLinks, it adds a delivery for
+// every webhook of the owner subscribed to the event
+const queueEvent = `INSERT INTO webhook_outbox (webhook_id, event, payload, next_attempt, created)
+	SELECT webhook_id, ?, ?, ?, ? FROM webhooks
+	WHERE owner = ? AND (events = '' OR ',' || events || ',' LIKE '%,' || ? || ',%')`
+
+// QueueEvent adds the event to the outbox and returns how many webhooks will receive it
+func (m *WebhookDBModel) QueueEvent(ctx context.Context, event *WebhookEvent) (int, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "queue_event")
+	defer end()
+	if event.Owner == "" {
+		return 0, nil
+	}
+	now := time.Now().UTC()
+	args := []any{event.Event, event.Payload, now, now, event.Owner, event.Event}
+	query := queueEvent
+	if event.Event == EventClickThresholdReached {
+		query += ` AND click_threshold > 0 AND click_threshold = ?`
+		args = append(args, event.Clicks)
+	}
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// QueueExpiredLinks queues EventLinkExpired for the active links of owners that
+// expired by now, each link is only announced once
+func (m *WebhookDBModel) QueueExpiredLinks(ctx context.Context, now time.Time, payload func(*ShortenerData) ([]byte, error)) (int, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "queue_expired_links")
+	defer end()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now = now.UTC()
+	query := selectURLs + ` WHERE u.expires_at IS NOT NULL AND u.expires_at <= ? AND NOT u.expiry_notified
+		AND u.owner != '' AND COALESCE(u.active, TRUE)`
+	rows, err := tx.QueryContext(ctx, query, now)
+	if err != nil {
+		return 0, err
+	}
+	var expired []*ShortenerData
+	for rows.Next() {
+		data, err := scanData(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, data)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, data := range expired {
+		body, err := payload(data)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.ExecContext(ctx, queueEvent, EventLinkExpired, body, now, now, data.Owner, EventLinkExpired)
+		if err != nil {
+			return 0, err
+		}
+		query = `UPDATE urls SET expiry_notified = TRUE WHERE domain = ? AND shortened_url_key = ?`
+		if _, err = tx.ExecContext(ctx, query, data.Domain, data.ShortenedURLKEY); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(expired), tx.Commit()
+}
+
+// PendingDeliveries returns the deliveries due by now, oldest first
+func (m *WebhookDBModel) PendingDeliveries(ctx context.Context, now time.Time, limit int) ([]*Delivery, error) {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "pending_deliveries")
+	defer end()
+	query := `SELECT o.delivery_id, o.webhook_id, w.url, w.secret, o.event, o.payload, o.attempts
+		FROM webhook_outbox o JOIN webhooks w ON w.webhook_id = o.webhook_id
+		WHERE o.delivered IS NULL AND o.next_attempt IS NOT NULL AND o.next_attempt <= ?
+		ORDER BY o.next_attempt, o.delivery_id LIMIT ?`
+	rows, err := m.DB.QueryContext(ctx, query, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*Delivery{}
+	for rows.Next() {
+		delivery := &Delivery{}
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.URL, &delivery.Secret, &delivery.Event,
+			&delivery.Payload, &delivery.Attempts)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// MarkDelivered records a successful delivery
+func (m *WebhookDBModel) MarkDelivered(ctx context.Context, id int64, at time.Time) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "mark_delivered")
+	defer end()
+	query := `UPDATE webhook_outbox SET attempts = attempts + 1, delivered = ?, last_error = '' WHERE delivery_id = ?`
+	return m.exec(ctx, query, at.UTC(), id)
+}
+
+// MarkFailed records a failed attempt, the delivery is retried at next or given up when next is zero
+func (m *WebhookDBModel) MarkFailed(ctx context.Context, id int64, attempts int, next time.Time, lastError string) error {
+	ctx, end := startQuery(ctx, m.QueryTimeout, "mark_failed")
+	defer end()
+	query := `UPDATE webhook_outbox SET attempts = ?, next_attempt = ?, last_error = ? WHERE delivery_id = ?`
+	var nextAttempt sql.NullTime
+	if !next.IsZero() {
+		nextAttempt = sql.NullTime{Time: next.UTC(), Valid: true}
+	}
+	return m.exec(ctx, query, attempts, nextAttempt, lastError, id)
+}
+
+// exec runs a statement that must change exactly one row
+func (m *WebhookDBModel) exec(ctx context.Context, query string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// IsWebhookEvent reports whether event is an event webhooks can subscribe to
+func IsWebhookEvent(event string) bool {
+	return slices.Contains(WebhookEvents, event)
+}
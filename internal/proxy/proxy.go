@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Proxies are the networks of the reverse proxies trusted to report the original
+// scheme, host and client of a request through the Forwarded and X-Forwarded-* headers
+type Proxies []netip.Prefix
+
+// ParseProxies parses a comma separated list of IPs and CIDRs, e.g. "10.0.0.0/8,127.0.0.1"
+func ParseProxies(s string) (Proxies, error) {
+	var proxies Proxies
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", field, err)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Trusts reports whether the request came directly from a trusted proxy
+func (p Proxies) Trusts(r *http.Request) bool {
+	if len(p) == 0 {
+		return false
+	}
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return p.contains(addrPort.Addr())
+}
+
+// contains reports whether addr is the address of a trusted proxy
+func (p Proxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scheme returns the scheme the client used, as reported by a trusted proxy or else of the connection
+func (p Proxies) Scheme(r *http.Request) string {
+	if p.Trusts(r) {
+		if proto := p.forwarded(r)["proto"]; proto == "http" || proto == "https" {
+			return proto
+		}
+		if proto := p.hopValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host the client asked for, as reported by a trusted proxy or else the Host header
+func (p Proxies) Host(r *http.Request) string {
+	if p.Trusts(r) {
+		if host := p.forwarded(r)["host"]; host != "" {
+			return host
+		}
+		if host := p.hopValue(r, "X-Forwarded-Host"); host != "" {
+			return host
+		}
+	}
+	return r.Host
+}
+
+// ClientIP returns the IP of the client, as reported by a trusted proxy or else of the
+// connection. Each proxy appends the address it received the request from, so the
+// addresses are walked from the right and the first one that isn't a trusted proxy is
+// the client, the addresses left of it may be sent by the client itself
+func (p Proxies) ClientIP(r *http.Request) string {
+	if p.Trusts(r) {
+		if client := p.forwarded(r)["for"]; client != "" {
+			return stripPort(client)
+		}
+		addresses := splitValues(r.Header.Get("X-Forwarded-For"))
+		if client := stripPort(addresses[len(addresses)-1-p.untrusted(addresses)]); client != "" {
+			return client
+		}
+	}
+	return stripPort(r.RemoteAddr)
+}
+
+// forwarded returns the parameters of the element of the RFC 7239 Forwarded header that
+// was added by the proxy the client connected to: the elements are walked from the right,
+// the element of the first address that isn't a trusted proxy. It is nil without the header
+func (p Proxies) forwarded(r *http.Request) map[string]string {
+	header := r.Header.Get("Forwarded")
+	if header == "" {
+		return nil
+	}
+	elements := strings.Split(header, ",")
+	var params map[string]string
+	for i := len(elements) - 1; i >= 0; i-- {
+		params = map[string]string{}
+		for _, pair := range strings.Split(elements[i], ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok {
+				params[strings.ToLower(key)] = strings.Trim(value, `"`)
+			}
+		}
+		if !p.trustsHop(params["for"]) {
+			break
+		}
+	}
+	return params
+}
+
+// untrusted returns the position from the right of the rightmost of the addresses that
+// isn't a trusted proxy, or of the leftmost when they are all trusted
+func (p Proxies) untrusted(addresses []string) int {
+	hop := 0
+	for ; hop < len(addresses)-1; hop++ {
+		if !p.trustsHop(addresses[len(addresses)-1-hop]) {
+			break
+		}
+	}
+	return hop
+}
+
+// hopValue returns the value of an X-Forwarded-* header added by the proxy the client
+// connected to. Like X-Forwarded-For each proxy appends to it, so it is the value at the
+// position from the right of the client address of X-Forwarded-For, the values left of
+// it may be sent by the client. A header with fewer values was overwritten by a proxy
+// rather than appended to and its leftmost value is used
+func (p Proxies) hopValue(r *http.Request, header string) string {
+	values := splitValues(r.Header.Get(header))
+	hop := p.untrusted(splitValues(r.Header.Get("X-Forwarded-For")))
+	return values[max(len(values)-1-hop, 0)]
+}
+
+// trustsHop reports whether a forwarded address is a trusted proxy, an address that
+// isn't an IP, e.g. "unknown" or an obfuscated identifier, isn't trusted
+func (p Proxies) trustsHop(address string) bool {
+	addr, err := netip.ParseAddr(stripPort(address))
+	return err == nil && p.contains(addr)
+}
+
+// splitValues splits a comma separated header value, it has one empty value when the header is empty
+func splitValues(value string) []string {
+	values := strings.Split(value, ",")
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// stripPort removes the port from host:port, [ipv6]:port and the Forwarded "[ipv6]" form
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.Trim(address, "[]")
+}
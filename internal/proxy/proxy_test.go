@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "Untrusted connection",
+			remoteAddr: "203.0.113.7:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Client of a trusted proxy",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Address sent by the client is skipped",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7, 10.0.0.2,10.0.0.3"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Only trusted proxies",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.2, 10.0.0.3"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "Forwarded header",
+			remoteAddr: "[2001:db8::1]:4711",
+			headers:    map[string]string{"Forwarded": `for=198.51.100.1, for="[2001:db8::2]:80";proto=https, for=10.0.0.2`},
+			want:       "2001:db8::2",
+		},
+		{
+			name:       "Unknown address in the Forwarded header",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"Forwarded": `for=198.51.100.1, for=unknown, for=10.0.0.2`},
+			want:       "unknown",
+		},
+		{
+			name:       "Forwarded header before X-Forwarded-For",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string]string{"Forwarded": "for=203.0.113.7", "X-Forwarded-For": "198.51.100.1"},
+			want:       "203.0.113.7",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+			if got := proxies.ClientIP(r); got != tc.want {
+				t.Errorf("got %q; want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestForwardedHost(t *testing.T) {
+	proxies, _ := ParseProxies("10.0.0.0/8")
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:4711"
+	// the first element is sent by the client, the second by the proxy it connected to
+	r.Header.Set("Forwarded", `for=198.51.100.1;host=evil.example.com;proto=http, for=203.0.113.7;host="sho.rt";proto=https`)
+
+	if host := proxies.Host(r); host != "sho.rt" {
+		t.Errorf("got host %q; want %q", host, "sho.rt")
+	}
+	if scheme := proxies.Scheme(r); scheme != "https" {
+		t.Errorf("got scheme %q; want %q", scheme, "https")
+	}
+}
+
+func TestXForwardedSchemeAndHost(t *testing.T) {
+	proxies, _ := ParseProxies("10.0.0.0/8")
+
+	testCases := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		wantScheme string
+		wantHost   string
+	}{
+		{
+			name:       "Untrusted connection",
+			remoteAddr: "203.0.113.7:4711",
+			headers:    map[string]string{"X-Forwarded-Proto": "https", "X-Forwarded-Host": "evil.example.com"},
+			wantScheme: "http",
+			wantHost:   "example.com",
+		},
+		{
+			name:       "Values sent by the client are skipped",
+			remoteAddr: "10.0.0.1:4711",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 203.0.113.7",
+				"X-Forwarded-Proto": "http, https",
+				"X-Forwarded-Host":  "evil.example.com, sho.rt",
+			},
+			wantScheme: "https",
+			wantHost:   "sho.rt",
+		},
+		{
+			name:       "Chain of trusted proxies",
+			remoteAddr: "10.0.0.1:4711",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 203.0.113.7, 10.0.0.2",
+				"X-Forwarded-Proto": "http, https, http",
+				"X-Forwarded-Host":  "evil.example.com, sho.rt, backend.internal",
+			},
+			wantScheme: "https",
+			wantHost:   "sho.rt",
+		},
+		{
+			name:       "Header overwritten by a proxy",
+			remoteAddr: "10.0.0.1:4711",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 203.0.113.7, 10.0.0.2",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "sho.rt",
+			},
+			wantScheme: "https",
+			wantHost:   "sho.rt",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for key, value := range tc.headers {
+				r.Header.Set(key, value)
+			}
+			if scheme := proxies.Scheme(r); scheme != tc.wantScheme {
+				t.Errorf("got scheme %q; want %q", scheme, tc.wantScheme)
+			}
+			if host := proxies.Host(r); host != tc.wantHost {
+				t.Errorf("got host %q; want %q", host, tc.wantHost)
+			}
+		})
+	}
+}
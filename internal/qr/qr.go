@@ -0,0 +1,151 @@
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Limits and defaults of the QR code options
+const (
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+)
+
+// Supported output formats
+const (
+	PNG = "png"
+	SVG = "svg"
+)
+
+var ErrInvalidLevel = errors.New("error correction level must be one of L, M, Q or H")
+
+// levels maps the standard names of the error correction levels, Q is called High by the library
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options configure how a QR code is rendered
+type Options struct {
+	// Size is the width and height of the image in pixels
+	Size int
+	// Level is the error correction level, L, M, Q or H
+	Level string
+	// Margin is the quiet zone around the code in modules, the standard asks for 4
+	Margin int
+}
+
+// DefaultOptions renders a 256px code with medium error correction and the standard margin
+func DefaultOptions() Options {
+	return Options{Size: DefaultSize, Level: "M", Margin: DefaultMargin}
+}
+
+// Validate checks the options are in the supported ranges
+func (o Options) Validate() error {
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d pixels", MinSize, MaxSize)
+	}
+	if _, ok := levels[strings.ToUpper(o.Level)]; !ok {
+		return ErrInvalidLevel
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("margin must be between 0 and %d modules", MaxMargin)
+	}
+	return nil
+}
+
+// Code is an encoded QR code ready to be rendered
+type Code struct {
+	// modules holds the dark modules including the margin, modules[y][x]
+	modules [][]bool
+	size    int
+}
+
+// Encode encodes content with the given options
+func Encode(content string, opts Options) (*Code, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	q, err := qrcode.New(content, levels[strings.ToUpper(opts.Level)])
+	if err != nil {
+		return nil, err
+	}
+	// the margin is added here so it can be configured
+	q.DisableBorder = true
+	bitmap := q.Bitmap()
+
+	n := len(bitmap) + 2*opts.Margin
+	modules := make([][]bool, n)
+	for y := range modules {
+		modules[y] = make([]bool, n)
+	}
+	for y, row := range bitmap {
+		copy(modules[y+opts.Margin][opts.Margin:], row)
+	}
+
+	return &Code{modules: modules, size: opts.Size}, nil
+}
+
+// WritePNG writes the code as a PNG image. Every module is drawn with the same
+// whole number of pixels so the code stays sharp, the left over pixels are
+// spread around the code
+func (c *Code) WritePNG(w io.Writer) error {
+	n := len(c.modules)
+	scale := max(c.size/n, 1)
+	size := max(c.size, n)
+	offset := (size - scale*n) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+	for y, row := range c.modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex(offset+x*scale+px, offset+y*scale+py, 1)
+				}
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// WriteSVG writes the code as an SVG image, each row of dark modules is drawn as horizontal runs of one path
+func (c *Code) WriteSVG(w io.Writer) error {
+	n := len(c.modules)
+	var path strings.Builder
+	for y, row := range c.modules {
+		for x := 0; x < n; x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < n && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">
+<rect width="%d" height="%d" fill="#ffffff"/>
+<path fill="#000000" d="%s"/>
+</svg>
+`, c.size, c.size, n, n, n, n, path.String())
+	return err
+}
@@ -0,0 +1,276 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// columns maps the normalized CSV headers of this and other shorteners to the fields of a record
+var columns = map[string]string{
+	"domain":           "domain",
+	"key":              "key",
+	"keyword":          "key",
+	"back_half":        "key",
+	"custom_back_half": "key",
+	// the short link of Bitly, the key is its last path segment
+	"link":             "link",
+	"short_link":       "link",
+	"short_url":        "link",
+	"shorturl":         "link",
+	"bitlink":          "link",
+	"id":               "link",
+	"original_url":     "original_url",
+	"long_url":         "original_url",
+	"url":              "original_url",
+	"destination":      "original_url",
+	"destination_url":  "original_url",
+	"owner":            "owner",
+	"title":            "title",
+	"notes":            "notes",
+	"tags":             "tags",
+	"clicks":           "clicks",
+	"total_clicks":     "clicks",
+	"user_clicks":      "clicks",
+	"active":           "active",
+	"interstitial":     "interstitial",
+	"expires_at":       "expires_at",
+	"created":          "created",
+	"created_at":       "created",
+	"created_date":     "created",
+	"timestamp":        "created",
+	"date":             "created",
+	"updated":          "updated",
+	"updated_at":       "updated",
+	"page_title":       "page_title",
+	"page_description": "page_description",
+	"page_image_url":   "page_image_url",
+	"page_favicon_url": "page_favicon_url",
+	"page_fetched":     "page_fetched",
+}
+
+// yourlsColumns are the columns of a YOURLS export without a header
+var yourlsColumns = []string{"key", "original_url", "title", "created", "", "clicks"}
+
+// timeLayouts are the times accepted in a CSV import
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02 15:04:05 MST",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// Reader reads the records of an import, Read returns io.EOF after the last record
+type Reader struct {
+	format string
+	// record is the number of the last record read, it is reported with its errors
+	record int
+	json   *json.Decoder
+	csv    *csv.Reader
+	header []string
+	// pending is a first line of a YOURLS export that turned out to be a record
+	pending []string
+}
+
+// NewReader creates the reader of an import format. Records of Bitly and YOURLS exports
+// have no domain as their domain is the one of the other shortener
+func NewReader(r io.Reader, format string) (*Reader, error) {
+	if err := checkFormat(format, ImportFormats); err != nil {
+		return nil, err
+	}
+	reader := &Reader{format: format}
+	if format == FormatNDJSON {
+		reader.json = json.NewDecoder(r)
+		return reader, nil
+	}
+
+	reader.csv = csv.NewReader(r)
+	reader.csv.FieldsPerRecord = -1
+	reader.csv.TrimLeadingSpace = true
+	first, err := reader.csv.Read()
+	if errors.Is(err, io.EOF) {
+		return reader, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+
+	reader.header = make([]string, len(first))
+	hasURL := false
+	for i, name := range first {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		name = strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+		reader.header[i] = columns[name]
+		hasURL = hasURL || reader.header[i] == "original_url"
+	}
+	if !hasURL {
+		if format != FormatYOURLS {
+			return nil, errors.New("header: missing the column of the original URL")
+		}
+		reader.header, reader.pending = yourlsColumns, first
+	}
+	return reader, nil
+}
+
+// Read returns the next record, errors report the number of the record
+func (r *Reader) Read() (*Record, error) {
+	r.record++
+	// records are active unless they say otherwise
+	record := &Record{Active: true}
+	if r.json != nil {
+		if err := r.json.Decode(record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("record %d: %w", r.record, err)
+		}
+		return record, nil
+	}
+
+	if r.header == nil {
+		return nil, io.EOF
+	}
+	fields := r.pending
+	r.pending = nil
+	if fields == nil {
+		var err error
+		if fields, err = r.csv.Read(); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("record %d: %w", r.record, err)
+		}
+	}
+	if err := r.parse(record, fields); err != nil {
+		return nil, fmt.Errorf("record %d: %w", r.record, err)
+	}
+	if r.format == FormatBitly || r.format == FormatYOURLS {
+		record.Domain = ""
+	}
+	return record, nil
+}
+
+// parse sets the fields of a CSV record
+func (r *Reader) parse(record *Record, fields []string) error {
+	var err error
+	for i, value := range fields {
+		if i >= len(r.header) {
+			break
+		}
+		value = strings.TrimSpace(value)
+		switch r.header[i] {
+		case "domain":
+			record.Domain = value
+		case "key":
+			record.Key = value
+		case "link":
+			if record.Key == "" {
+				record.Key = linkKey(value)
+			}
+		case "original_url":
+			record.OriginalURL = value
+		case "owner":
+			record.Owner = value
+		case "title":
+			record.Title = value
+		case "notes":
+			record.Notes = value
+		case "tags":
+			if value != "" {
+				record.Tags = strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == '|' })
+			}
+		case "clicks":
+			if value != "" {
+				if record.Clicks, err = strconv.Atoi(value); err != nil || record.Clicks < 0 {
+					return fmt.Errorf("invalid clicks %q", value)
+				}
+			}
+		case "active":
+			if value != "" {
+				if record.Active, err = strconv.ParseBool(value); err != nil {
+					return fmt.Errorf("invalid active %q", value)
+				}
+			}
+		case "interstitial":
+			if value != "" {
+				if record.Interstitial, err = strconv.ParseBool(value); err != nil {
+					return fmt.Errorf("invalid interstitial %q", value)
+				}
+			}
+		case "expires_at":
+			if record.ExpiresAt, err = parseTime(value); err != nil {
+				return err
+			}
+		case "created":
+			if record.Created, err = parseTime(value); err != nil {
+				return err
+			}
+		case "updated":
+			if record.Updated, err = parseTime(value); err != nil {
+				return err
+			}
+		case "page_title":
+			page(record).Title = value
+		case "page_description":
+			page(record).Description = value
+		case "page_image_url":
+			page(record).ImageURL = value
+		case "page_favicon_url":
+			page(record).FaviconURL = value
+		case "page_fetched":
+			if page(record).FetchedAt, err = parseTime(value); err != nil {
+				return err
+			}
+		}
+	}
+	// the empty columns of a link whose page wasn't fetched leave it without a page
+	if record.Page != nil && *record.Page == (Page{}) {
+		record.Page = nil
+	}
+	return nil
+}
+
+// page returns the page of a record, it is created by the first page column
+func page(record *Record) *Page {
+	if record.Page == nil {
+		record.Page = &Page{}
+	}
+	return record.Page
+}
+
+// linkKey returns the key of a short link such as https://bit.ly/3xYzAb1
+func linkKey(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	path := strings.Trim(u.Path, "/")
+	return path[strings.LastIndex(path, "/")+1:]
+}
+
+func parseTime(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			t = t.UTC()
+			return &t, nil
+		}
+	}
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.Unix(unix, 0).UTC()
+		return &t, nil
+	}
+	return nil, fmt.Errorf("invalid time %q", value)
+}
@@ -0,0 +1,140 @@
+// Package transfer reads and writes links in the formats used to export them and to
+// import them from this or other shorteners
+package transfer
+
+import (
+	"fmt"
+	"go-url-shortener/internal/models"
+	"slices"
+	"time"
+)
+
+// Formats of an export or import
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+	// FormatBitly is the CSV export of Bitly links
+	FormatBitly = "bitly"
+	// FormatYOURLS is the CSV export of YOURLS links, with or without a header
+	FormatYOURLS = "yourls"
+)
+
+// ExportFormats can be written, ImportFormats can be read
+var (
+	ExportFormats = []string{FormatNDJSON, FormatCSV}
+	ImportFormats = []string{FormatNDJSON, FormatCSV, FormatBitly, FormatYOURLS}
+)
+
+// Record is a link in an export or import
+type Record struct {
+	Domain       string     `json:"domain"`
+	Key          string     `json:"key"`
+	OriginalURL  string     `json:"original_url"`
+	Owner        string     `json:"owner,omitempty"`
+	Title        string     `json:"title,omitempty"`
+	Notes        string     `json:"notes,omitempty"`
+	Tags         []string   `json:"tags,omitempty"`
+	Clicks       int        `json:"clicks"`
+	Active       bool       `json:"active"`
+	Interstitial bool       `json:"interstitial,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Created      *time.Time `json:"created,omitempty"`
+	Updated      *time.Time `json:"updated,omitempty"`
+	Page         *Page      `json:"page,omitempty"`
+}
+
+// Page is the metadata fetched from the destination page of a link, a link whose page
+// wasn't fetched yet has none
+type Page struct {
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	ImageURL    string     `json:"image_url,omitempty"`
+	FaviconURL  string     `json:"favicon_url,omitempty"`
+	FetchedAt   *time.Time `json:"fetched_at,omitempty"`
+}
+
+// FromLink creates the record of a link
+func FromLink(data *models.ShortenerData) *Record {
+	record := &Record{
+		Domain:       data.Domain,
+		Key:          data.ShortenedURLKEY,
+		OriginalURL:  data.OriginalURL,
+		Owner:        data.Owner,
+		Title:        data.Title,
+		Notes:        data.Notes,
+		Tags:         data.Tags,
+		Clicks:       data.Clicks,
+		Active:       !data.Deactivated,
+		Interstitial: data.Interstitial,
+		ExpiresAt:    optionalTime(data.ExpiresAt),
+		Created:      optionalTime(data.Created),
+		Updated:      optionalTime(data.Updated),
+	}
+	if !data.Page.FetchedAt.IsZero() {
+		record.Page = &Page{
+			Title:       data.Page.Title,
+			Description: data.Page.Description,
+			ImageURL:    data.Page.ImageURL,
+			FaviconURL:  data.Page.FaviconURL,
+			FetchedAt:   optionalTime(data.Page.FetchedAt),
+		}
+	}
+	return record
+}
+
+// Link creates the link of a record, the update time isn't kept as importing updates the link
+func (r *Record) Link() *models.ShortenerData {
+	data := &models.ShortenerData{
+		Domain:          r.Domain,
+		Owner:           r.Owner,
+		OriginalURL:     r.OriginalURL,
+		ShortenedURLKEY: r.Key,
+		Clicks:          r.Clicks,
+		Title:           r.Title,
+		Notes:           r.Notes,
+		Tags:            r.Tags,
+		Interstitial:    r.Interstitial,
+		Deactivated:     !r.Active,
+	}
+	if r.ExpiresAt != nil {
+		data.ExpiresAt = *r.ExpiresAt
+	}
+	if r.Created != nil {
+		data.Created = *r.Created
+	}
+	if r.Page != nil {
+		data.Page = models.PageMetadata{
+			Title:       r.Page.Title,
+			Description: r.Page.Description,
+			ImageURL:    r.Page.ImageURL,
+			FaviconURL:  r.Page.FaviconURL,
+		}
+		if r.Page.FetchedAt != nil {
+			data.Page.FetchedAt = *r.Page.FetchedAt
+		}
+	}
+	return data
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// ContentType is the media type of an export format
+func ContentType(format string) string {
+	if format == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+func checkFormat(format string, formats []string) error {
+	if !slices.Contains(formats, format) {
+		return fmt.Errorf("unknown format %q", format)
+	}
+	return nil
+}
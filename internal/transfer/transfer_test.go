@@ -0,0 +1,160 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, input, format string) ([]*Record, error) {
+	t.Helper()
+	reader, err := NewReader(strings.NewReader(input), format)
+	if err != nil {
+		return nil, err
+	}
+	records := []*Record{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	records := []*Record{
+		{
+			Domain:      "go.example.com",
+			Key:         "abcabc1234567890",
+			OriginalURL: "https://example.com/a,b",
+			Owner:       "marketing",
+			Title:       `Summer "sale"`,
+			Notes:       "Printed on the posters\nand flyers",
+			Tags:        []string{"campaign", "summer"},
+			Clicks:      19,
+			Active:      true,
+			Created:     &created,
+		},
+		{Key: "3xYzAb1", OriginalURL: "https://example.com/", Interstitial: true, ExpiresAt: &created},
+		{
+			Key:         "summer-sale_24",
+			OriginalURL: "https://example.com/sale",
+			Active:      true,
+			Page: &Page{Title: "Sale", Description: "Everything must go", ImageURL: "https://example.com/og.png",
+				FaviconURL: "https://example.com/favicon.ico", FetchedAt: &created},
+		},
+	}
+
+	for _, format := range ExportFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				if err = w.Write(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err = w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readAll(t, buf.String(), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, records) {
+				t.Errorf("got %+v; want %+v", got, records)
+			}
+		})
+	}
+}
+
+func TestImportFormats(t *testing.T) {
+	created := time.Date(2023, 5, 1, 10, 11, 12, 0, time.UTC)
+	testCases := []struct {
+		name    string
+		format  string
+		input   string
+		want    []*Record
+		wantErr string
+	}{
+		{
+			name:   "Bitly export",
+			format: FormatBitly,
+			input: "Title,Bitlink,Long URL,Created,Clicks,Tags\n" +
+				"Launch,https://bit.ly/3xYzAb1,https://example.com/launch,2023-05-01 10:11:12,7,news;launch\n",
+			want: []*Record{{Key: "3xYzAb1", OriginalURL: "https://example.com/launch", Title: "Launch", Clicks: 7,
+				Active: true, Tags: []string{"news", "launch"}, Created: &created}},
+		},
+		{
+			name:   "YOURLS export with a header",
+			format: FormatYOURLS,
+			input:  "keyword,url,title,timestamp,ip,clicks\nlaunch,https://example.com/launch,Launch,2023-05-01 10:11:12,127.0.0.1,3\n",
+			want: []*Record{{Key: "launch", OriginalURL: "https://example.com/launch", Title: "Launch", Clicks: 3,
+				Active: true, Created: &created}},
+		},
+		{
+			name:   "YOURLS export without a header",
+			format: FormatYOURLS,
+			input:  "launch,https://example.com/launch,Launch,2023-05-01 10:11:12,127.0.0.1,3\n",
+			want: []*Record{{Key: "launch", OriginalURL: "https://example.com/launch", Title: "Launch", Clicks: 3,
+				Active: true, Created: &created}},
+		},
+		{
+			name:   "Records are active unless they say otherwise",
+			format: FormatNDJSON,
+			input:  `{"key":"launch","original_url":"https://example.com/launch"}` + "\n" + `{"original_url":"https://example.com/","active":false}`,
+			want: []*Record{
+				{Key: "launch", OriginalURL: "https://example.com/launch", Active: true},
+				{OriginalURL: "https://example.com/"},
+			},
+		},
+		{
+			name:    "CSV without the original URL",
+			format:  FormatCSV,
+			input:   "key,title\nlaunch,Launch\n",
+			wantErr: "header: missing the column of the original URL",
+		},
+		{
+			name:    "Invalid clicks",
+			format:  FormatCSV,
+			input:   "key,url,clicks\nlaunch,https://example.com/launch,3\nsale,https://example.com/sale,many\n",
+			wantErr: `record 2: invalid clicks "many"`,
+		},
+		{
+			name:    "Unknown format",
+			format:  "xml",
+			wantErr: `unknown format "xml"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readAll(t, tc.input, tc.format)
+			if tc.wantErr != "" {
+				if err == nil || err.Error() != tc.wantErr {
+					t.Errorf("got error %v; want %s", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v; want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader are the columns of a CSV export, tags are comma separated within their column
+var csvHeader = []string{"domain", "key", "original_url", "owner", "title", "notes", "tags", "clicks", "active",
+	"interstitial", "expires_at", "created", "updated", "page_title", "page_description", "page_image_url",
+	"page_favicon_url", "page_fetched"}
+
+// Writer writes the records of an export, Flush must be called once every record was written
+type Writer interface {
+	Write(record *Record) error
+	Flush() error
+}
+
+// NewWriter creates the writer of an export format
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	if err := checkFormat(format, ExportFormats); err != nil {
+		return nil, err
+	}
+	if format == FormatNDJSON {
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	}
+	cw := csv.NewWriter(w)
+	return &csvWriter{w: cw}, cw.Write(csvHeader)
+}
+
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (w *ndjsonWriter) Write(record *Record) error {
+	return w.enc.Encode(record)
+}
+
+func (w *ndjsonWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (w *csvWriter) Write(record *Record) error {
+	page := record.Page
+	if page == nil {
+		page = &Page{}
+	}
+	return w.w.Write([]string{
+		record.Domain,
+		record.Key,
+		record.OriginalURL,
+		record.Owner,
+		record.Title,
+		record.Notes,
+		strings.Join(record.Tags, ","),
+		strconv.Itoa(record.Clicks),
+		strconv.FormatBool(record.Active),
+		strconv.FormatBool(record.Interstitial),
+		formatTime(record.ExpiresAt),
+		formatTime(record.Created),
+		formatTime(record.Updated),
+		page.Title,
+		page.Description,
+		page.ImageURL,
+		page.FaviconURL,
+		formatTime(page.FetchedAt),
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
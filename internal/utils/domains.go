@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"net"
+	"regexp"
+	"strings"
+)
+
+const MaxOwnerLength = 64
+
+var (
+	hostPattern  = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	ownerPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@-]*$`)
+)
+
+// NormalizeHost lower cases a host and strips the port and trailing dot,
+// so the Host header "Go.Example.com:443" matches the domain "go.example.com"
+func NormalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+// IsValidHost checks a normalized host name
+func IsValidHost(host string) bool {
+	return len(host) <= 253 && hostPattern.MatchString(host)
+}
+
+// IsValidOwner checks an owner identifier, owners are stored comma separated so commas are not allowed
+func IsValidOwner(owner string) bool {
+	return len(owner) <= MaxOwnerLength && ownerPattern.MatchString(owner)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+const MaxTagLength = 32
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// NormalizeTag trims and lower cases a tag so "Summer-Sale " and "summer-sale" are the same tag
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// IsValidTag checks a normalized tag, tags are stored comma separated so only
+// letters, numbers, dashes and underscores are allowed
+func IsValidTag(tag string) bool {
+	if len(tag) == 0 || len(tag) > MaxTagLength {
+		return false
+	}
+	return tagPattern.MatchString(tag)
+}
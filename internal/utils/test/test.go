@@ -14,9 +14,12 @@ type TestServer struct {
 }
 
 type TestCases struct {
-	Name                    string
-	Method                  string
-	URLPath                 string
+	Name    string
+	Method  string
+	URLPath string
+	// Host overrides the Host header of the request when set
+	Host                    string
+	Headers                 map[string]string
 	Body                    io.Reader
 	ExpectedStatusCode      int
 	ExpectedResponseMessage string
@@ -48,6 +51,12 @@ func RunTestCase(
 	if err != nil {
 		t.Fatal(err)
 	}
+	if tc.Host != "" {
+		req.Host = tc.Host
+	}
+	for name, value := range tc.Headers {
+		req.Header.Set(name, value)
+	}
 
 	rs, err := ts.Client().Do(req)
 	if err != nil {
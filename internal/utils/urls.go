@@ -2,14 +2,12 @@ package utils
 
 import (
 	"encoding/json"
-	"fmt"
 	h "go-url-shortener/internal/api/http"
+	"math/rand/v2"
 	"net/http"
 	"net/url"
-	"regexp"
-	"time"
-
-	"math/rand"
+	"slices"
+	"strings"
 
 	urlverifier "github.com/davidmytton/url-verifier"
 )
@@ -17,6 +15,8 @@ import (
 const (
 	Charset      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	URLKeyLength = 16
+	// MaxURLKeyLength bounds the keys imported from other shorteners, which are usually shorter than the generated ones
+	MaxURLKeyLength = 64
 )
 
 // Verify if the URL supplied is a genuine and workable URL
@@ -39,42 +39,71 @@ func IsValidURL(URL string) bool {
 	return true
 }
 
+// SendErrorResponse sends an error with the generic code of its status
 func SendErrorResponse(w http.ResponseWriter, errorMessage string, statusCode int) {
+	SendError(w, statusCode, h.StatusCode(statusCode), errorMessage)
+}
+
+// SendError sends an error in the envelope of the API, details lists the invalid fields
+// of the request. The request ID is the one the middleware set on the response
+func SendError(w http.ResponseWriter, statusCode int, code, message string, details ...h.FieldError) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(h.URLResponse{
-		Message: errorMessage,
+	json.NewEncoder(w).Encode(h.ErrorResponse{
+		Message: message,
+		Error: h.ErrorBody{
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestID: w.Header().Get(h.RequestIDHeader),
+		},
 	})
 }
 
+// KeyExtraChars may appear in the keys imported from other shorteners, such as the
+// custom keywords of YOURLS and Bitly, the generated keys never use them
+const KeyExtraChars = "-_"
+
+// IsValidURLKey checks a key uses the charset of the generated keys or KeyExtraChars,
+// imported keys keep their length so any length up to MaxURLKeyLength is accepted
 func IsValidURLKey(key string) bool {
-	if len(key) != URLKeyLength {
+	if len(key) == 0 || len(key) > MaxURLKeyLength {
 		return false
 	}
-	// use the same charset that generates the key to check if the received key is valid
-	pattern := fmt.Sprintf("^[%s]+$", regexp.QuoteMeta(Charset))
-	match, err := regexp.MatchString(pattern, key)
-	if err != nil {
-		return false
+	for _, c := range key {
+		if !strings.ContainsRune(Charset, c) && !strings.ContainsRune(KeyExtraChars, c) {
+			return false
+		}
 	}
+	return true
+}
 
-	return match
+// reservedKeys are the first path segments of the fixed routes of App.Routes, a link with
+// one of them as its key can't be reached when the keys are served at the root
+var reservedKeys = []string{
+	"api", "backups", "docs", "domains", "export", "graphql", "healthz",
+	"import", "links", "metrics", "ping", "readyz", "shorten", "webhooks",
 }
 
-func randomGenerator() *rand.Rand {
-	return rand.New(rand.NewSource(time.Now().UnixNano()))
+// IsReservedKey reports whether a key is the name of a fixed route, the case is ignored
+// because the router redirects a path in another case to the route
+func IsReservedKey(key string) bool {
+	return slices.ContainsFunc(reservedKeys, func(reserved string) bool {
+		return strings.EqualFold(key, reserved)
+	})
 }
 
-func generateRandomKey(length int) string {
-	r := randomGenerator()
+// GenerateKey generates a random key of the given length from Charset. The source is
+// shared and safe for concurrent use, a source seeded per call with the time gives
+// requests arriving in the same instant the same key
+func GenerateKey(length int) string {
 	key := make([]byte, length)
 	for i := range key {
-		key[i] = Charset[r.Intn(len(Charset))]
+		key[i] = Charset[rand.IntN(len(Charset))]
 	}
 	return string(key)
 }
 
 func GenerateShortURLKey() string {
-	key := generateRandomKey(URLKeyLength)
-	return key
+	return GenerateKey(URLKeyLength)
 }
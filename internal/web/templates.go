@@ -0,0 +1,63 @@
+package web
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed templates
+var files embed.FS
+
+// Names of the pages that can be rendered
+const (
+	PreviewPage      = "preview.html"
+	InterstitialPage = "interstitial.html"
+	HomePage         = "home.html"
+)
+
+// pages holds every page parsed together with the base layout
+var pages = map[string]*template.Template{}
+
+func init() {
+	for _, name := range []string{PreviewPage, InterstitialPage, HomePage} {
+		pages[name] = template.Must(template.ParseFS(files, "templates/base.html", "templates/"+name))
+	}
+}
+
+// LinkPage is the data shown by the preview and interstitial pages
+type LinkPage struct {
+	ShortURL    string
+	OriginalURL string
+	// Host is the host of the destination, shown so users know where they are going
+	Host        string
+	Title       string
+	Description string
+	ImageURL    string
+	FaviconURL  string
+	Clicks      int
+}
+
+// HomeData configures the form and the recent links of the home page
+type HomeData struct {
+	MaxURLLength   int
+	MaxTitleLength int
+	MaxOwnerLength int
+	// RecentLinks is how many of the links shortened in the browser are listed
+	RecentLinks int
+}
+
+// Render writes the page with the given status code, the page is rendered into a
+// buffer first so a template error doesn't leave a half written response
+func Render(w http.ResponseWriter, status int, page string, data any) error {
+	var buf bytes.Buffer
+	if err := pages[page].ExecuteTemplate(&buf, "base", data); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_, err := buf.WriteTo(w)
+	return err
+}
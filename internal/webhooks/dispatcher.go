@@ -0,0 +1,363 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-url-shortener/internal/metadata"
+	"go-url-shortener/internal/models"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Defaults of the Options
+const (
+	DefaultInterval    = 5 * time.Second
+	DefaultMaxAttempts = 8
+	DefaultBaseDelay   = 30 * time.Second
+	DefaultMaxDelay    = time.Hour
+	// DefaultTimeout bounds a single delivery
+	DefaultTimeout = 10 * time.Second
+	// batchSize is how many deliveries are sent per round
+	batchSize = 50
+)
+
+// Options tune how often the outbox is polled and how failed deliveries are retried
+type Options struct {
+	// Interval is how often the outbox is checked for due deliveries and expired links
+	Interval time.Duration
+	// MaxAttempts is how many times a delivery is tried before it is given up
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure, it doubles with every further failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultOptions are used for the options left zero
+var DefaultOptions = Options{
+	Interval:    DefaultInterval,
+	MaxAttempts: DefaultMaxAttempts,
+	BaseDelay:   DefaultBaseDelay,
+	MaxDelay:    DefaultMaxDelay,
+}
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Link      LinkPayload `json:"link"`
+}
+
+// LinkPayload describes the link an event is about
+type LinkPayload struct {
+	Domain      string     `json:"domain"`
+	Key         string     `json:"key"`
+	Owner       string     `json:"owner"`
+	OriginalURL string     `json:"original_url"`
+	Title       string     `json:"title"`
+	Tags        []string   `json:"tags"`
+	Clicks      int        `json:"clicks"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Active      bool       `json:"active"`
+}
+
+// NewPayload builds the body of an event about a link
+func NewPayload(event string, data *models.ShortenerData, now time.Time) ([]byte, error) {
+	link := LinkPayload{
+		Domain:      data.Domain,
+		Key:         data.ShortenedURLKEY,
+		Owner:       data.Owner,
+		OriginalURL: data.OriginalURL,
+		Title:       data.Title,
+		Tags:        data.Tags,
+		Clicks:      data.Clicks,
+		Active:      !data.Deactivated,
+	}
+	if link.Tags == nil {
+		link.Tags = []string{}
+	}
+	if !data.ExpiresAt.IsZero() {
+		expiresAt := data.ExpiresAt.UTC()
+		link.ExpiresAt = &expiresAt
+	}
+	return json.Marshal(Payload{Event: event, CreatedAt: now.UTC(), Link: link})
+}
+
+// Dispatcher queues the events of links in the outbox and delivers them to the
+// webhooks of their owners in the background. Deliveries are kept in the outbox
+// until they succeed so they survive restarts, failed ones are retried with an
+// exponential backoff
+type Dispatcher struct {
+	store   models.WebhookInterface
+	client  *http.Client
+	logger  *slog.Logger
+	opts    Options
+	wake    chan struct{}
+	done    chan struct{}
+	stopped atomic.Bool
+	// lastRound is when the outbox was last processed, it tells whether the loop is stuck
+	lastRound atomic.Int64
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+// NewDispatcher starts delivering the outbox, Shutdown stops it. A nil client
+// uses NewClient, which only connects to public addresses
+func NewDispatcher(store models.WebhookInterface, client *http.Client, logger *slog.Logger, opts Options) *Dispatcher {
+	if client == nil {
+		client = NewClient()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultOptions.Interval
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = DefaultOptions.BaseDelay
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = DefaultOptions.MaxDelay
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		store:  store,
+		client: client,
+		logger: logger,
+		opts:   opts,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	d.lastRound.Store(time.Now().UnixNano())
+	d.wg.Add(1)
+	go d.run()
+	return d
+}
+
+// Notify queues an event about a link for the webhooks of its owner, links without
+// an owner have no webhooks. A failure is logged, it never fails the change of the link
+func (d *Dispatcher) Notify(ctx context.Context, event string, data *models.ShortenerData) {
+	d.queue(ctx, event, data, 0)
+}
+
+// Clicked queues EventClickThresholdReached for the webhooks whose threshold is exactly clicks,
+// clicks is the count returned by IncreaseClicks so every threshold is only reached once
+func (d *Dispatcher) Clicked(ctx context.Context, data *models.ShortenerData, clicks int) {
+	clicked := *data
+	clicked.Clicks = clicks
+	d.queue(ctx, models.EventClickThresholdReached, &clicked, clicks)
+}
+
+func (d *Dispatcher) queue(ctx context.Context, event string, data *models.ShortenerData, clicks int) {
+	if data.Owner == "" {
+		return
+	}
+	payload, err := NewPayload(event, data, time.Now())
+	if err == nil {
+		var n int
+		n, err = d.store.QueueEvent(ctx, &models.WebhookEvent{Owner: data.Owner, Event: event, Clicks: clicks, Payload: payload})
+		if n > 0 {
+			d.Wake()
+		}
+	}
+	if err != nil {
+		d.logger.ErrorContext(ctx, "failed to queue the webhook event", "event", event, "domain", data.Domain,
+			"key", data.ShortenedURLKEY, "error", err)
+	}
+}
+
+// Wake processes the outbox now instead of waiting for the next interval
+func (d *Dispatcher) Wake() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Shutdown stops the dispatcher after the current round, deliveries still in the
+// outbox are sent after the next start. When ctx is done first the round is cancelled
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	if d.stopped.Swap(true) {
+		return nil
+	}
+	close(d.done)
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-finished
+		return ctx.Err()
+	}
+}
+
+// Check fails when the dispatcher was shut down or hasn't processed the outbox for a while
+func (d *Dispatcher) Check(ctx context.Context) error {
+	if d.stopped.Load() {
+		return errors.New("the webhook dispatcher is stopped")
+	}
+	last := time.Unix(0, d.lastRound.Load())
+	// a round may send a whole batch of slow deliveries
+	if stuck := 2*d.opts.Interval + batchSize*DefaultTimeout; time.Since(last) > stuck {
+		return fmt.Errorf("the webhook dispatcher has not run since %s", last.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.round()
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// round queues the events of the links that expired and sends the due deliveries
+func (d *Dispatcher) round() {
+	defer func() { d.lastRound.Store(time.Now().UnixNano()) }()
+
+	now := time.Now()
+	_, err := d.store.QueueExpiredLinks(d.ctx, now, func(data *models.ShortenerData) ([]byte, error) {
+		return NewPayload(models.EventLinkExpired, data, now)
+	})
+	if err != nil {
+		d.logger.Error("failed to queue the expired links", "error", err)
+	}
+
+	deliveries, err := d.store.PendingDeliveries(d.ctx, now, batchSize)
+	if err != nil {
+		d.logger.Error("failed to read the webhook outbox", "error", err)
+		return
+	}
+	for _, delivery := range deliveries {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.deliver(delivery)
+	}
+}
+
+// deliver sends one delivery and records the outcome in the outbox
+func (d *Dispatcher) deliver(delivery *models.Delivery) {
+	err := d.send(delivery)
+	attempts := delivery.Attempts + 1
+	if err == nil {
+		if err = d.store.MarkDelivered(d.ctx, delivery.ID, time.Now()); err != nil {
+			d.logger.Error("failed to mark the webhook delivery as delivered", "delivery", delivery.ID, "error", err)
+		}
+		return
+	}
+
+	var next time.Time
+	if attempts < d.opts.MaxAttempts {
+		next = time.Now().Add(d.Backoff(attempts))
+		d.logger.Warn("webhook delivery failed, retrying", "delivery", delivery.ID, "url", delivery.URL,
+			"attempts", attempts, "next_attempt", next, "error", err)
+	} else {
+		d.logger.Error("webhook delivery failed, giving up", "delivery", delivery.ID, "url", delivery.URL,
+			"attempts", attempts, "error", err)
+	}
+	if err = d.store.MarkFailed(d.ctx, delivery.ID, attempts, next, err.Error()); err != nil {
+		d.logger.Error("failed to record the webhook delivery failure", "delivery", delivery.ID, "error", err)
+	}
+}
+
+// Backoff is the wait before the next attempt after the given number of failed attempts
+func (d *Dispatcher) Backoff(attempts int) time.Duration {
+	delay := d.opts.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= d.opts.MaxDelay {
+			return d.opts.MaxDelay
+		}
+	}
+	return delay
+}
+
+// send posts the payload, any 2xx answer counts as delivered
+func (d *Dispatcher) send(delivery *models.Delivery) error {
+	ctx, cancel := context.WithTimeout(d.ctx, DefaultTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-url-shortener-webhooks/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// NewClient creates the client deliveries are sent with, like the metadata fetcher it
+// only connects to public addresses so a webhook can't reach internal services
+func NewClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !metadata.IsPublicAddr(addrPort.Addr()) {
+				return metadata.ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: DefaultTimeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		// a redirect could lead the signed payload somewhere else
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
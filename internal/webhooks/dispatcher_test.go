@@ -0,0 +1,174 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"go-url-shortener/internal/models"
+	"go-url-shortener/internal/models/mocks"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver records the deliveries it gets after failing the first failures of them
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	bodies   [][]byte
+	headers  []http.Header
+	received chan struct{}
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.failures > 0 {
+		rc.failures--
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	rc.received <- struct{}{}
+}
+
+func newDispatcher(t *testing.T, store models.WebhookInterface, failures int, opts Options) (*Dispatcher, *receiver) {
+	rc := &receiver{failures: failures, received: make(chan struct{}, 10)}
+	ts := httptest.NewServer(rc)
+	t.Cleanup(ts.Close)
+	store.CreateWebhook(context.Background(), &models.Webhook{Owner: "marketing", URL: ts.URL, Secret: "whsec_test",
+		ClickThreshold: 100})
+	d := NewDispatcher(store, ts.Client(), slog.New(slog.NewTextHandler(io.Discard, nil)), opts)
+	t.Cleanup(func() { d.Shutdown(context.Background()) })
+	return d, rc
+}
+
+func waitDelivery(t *testing.T, rc *receiver) {
+	t.Helper()
+	select {
+	case <-rc.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the webhook was not delivered")
+	}
+}
+
+func TestDeliverSigned(t *testing.T) {
+	store := &mocks.MockWebhooks{}
+	d, rc := newDispatcher(t, store, 0, Options{Interval: time.Hour})
+
+	link := &models.ShortenerData{Domain: "go.example.com", ShortenedURLKEY: "abcabc1234567890", Owner: "marketing",
+		OriginalURL: "https://github.com/"}
+	d.Notify(context.Background(), models.EventLinkCreated, link)
+	waitDelivery(t, rc)
+
+	rc.mu.Lock()
+	body, header := rc.bodies[0], rc.headers[0]
+	rc.mu.Unlock()
+	if err := Verify("whsec_test", header.Get(SignatureHeader), body, time.Now(), DefaultTolerance); err != nil {
+		t.Errorf("got %v; want a valid signature", err)
+	}
+	if err := Verify("whsec_other", header.Get(SignatureHeader), body, time.Now(), DefaultTolerance); err != ErrInvalidSignature {
+		t.Errorf("got %v; want %v", err, ErrInvalidSignature)
+	}
+	if got := header.Get(EventHeader); got != models.EventLinkCreated {
+		t.Errorf("got event %s; want %s", got, models.EventLinkCreated)
+	}
+
+	var payload Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Link.Key != "abcabc1234567890" || payload.Link.Domain != "go.example.com" || !payload.Link.Active {
+		t.Errorf("got link %+v", payload.Link)
+	}
+
+	// links without an owner have no webhooks
+	d.Notify(context.Background(), models.EventLinkCreated, &models.ShortenerData{ShortenedURLKEY: "abcabc1234568789"})
+	if n := len(store.Deliveries); n != 1 {
+		t.Errorf("got %d deliveries; want 1", n)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	store := &mocks.MockWebhooks{}
+	d, rc := newDispatcher(t, store, 2, Options{Interval: 10 * time.Millisecond, BaseDelay: 10 * time.Millisecond})
+
+	d.Notify(context.Background(), models.EventLinkUpdated, &models.ShortenerData{Owner: "marketing"})
+	waitDelivery(t, rc)
+	d.Shutdown(context.Background())
+
+	delivery := store.Delivery(1)
+	if !delivery.Delivered || delivery.Attempts != 3 {
+		t.Errorf("got delivered %t after %d attempts; want delivered after 3", delivery.Delivered, delivery.Attempts)
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	store := &mocks.MockWebhooks{}
+	d, _ := newDispatcher(t, store, 100, Options{Interval: 10 * time.Millisecond, BaseDelay: time.Millisecond, MaxAttempts: 3})
+
+	d.Notify(context.Background(), models.EventLinkUpdated, &models.ShortenerData{Owner: "marketing"})
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Delivery(1).Attempts < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	d.Shutdown(context.Background())
+
+	delivery := store.Delivery(1)
+	if delivery.Delivered || delivery.Attempts != 3 || !delivery.NextAttempt.IsZero() {
+		t.Errorf("got %d attempts, next attempt %s; want 3 attempts and no next attempt", delivery.Attempts, delivery.NextAttempt)
+	}
+	if delivery.LastError != "webhook answered 502 Bad Gateway" {
+		t.Errorf("got last error %q", delivery.LastError)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{opts: Options{BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute}}
+	want := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, delay := range want {
+		if got := d.Backoff(i + 1); got != delay {
+			t.Errorf("got %s after %d attempts; want %s", got, i+1, delay)
+		}
+	}
+}
+
+func TestLinkEvents(t *testing.T) {
+	links := &mocks.MockShortenerData{MockData: map[string]*models.ShortenerData{
+		"abcabc1234561111": {
+			ShortenedURLKEY: "abcabc1234561111",
+			Owner:           "marketing",
+			ExpiresAt:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}}
+	store := &mocks.MockWebhooks{Links: links}
+	d, rc := newDispatcher(t, store, 0, Options{Interval: 10 * time.Millisecond})
+
+	// the expired link is announced by the first round, only once
+	waitDelivery(t, rc)
+	// the threshold is announced when it is reached, not after
+	d.Clicked(context.Background(), &models.ShortenerData{Owner: "marketing"}, 99)
+	d.Clicked(context.Background(), &models.ShortenerData{Owner: "marketing"}, 100)
+	d.Clicked(context.Background(), &models.ShortenerData{Owner: "marketing"}, 101)
+	waitDelivery(t, rc)
+	time.Sleep(50 * time.Millisecond)
+	d.Shutdown(context.Background())
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	want := []string{models.EventLinkExpired, models.EventClickThresholdReached}
+	if len(rc.headers) != len(want) {
+		t.Fatalf("got %d deliveries; want %d", len(rc.headers), len(want))
+	}
+	for i, event := range want {
+		if got := rc.headers[i].Get(EventHeader); got != event {
+			t.Errorf("got event %s; want %s", got, event)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a delivery
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// DefaultTolerance is how old a signature Verify accepts, it stops replays of old deliveries
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrExpiredSignature = errors.New("webhook signature is too old")
+)
+
+// NewSecret generates the secret a webhook signs its payloads with
+func NewSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign returns the signature header of a payload sent at timestamp, in the form
+// t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<payload>">
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, payload))
+}
+
+// Verify checks the signature header of a payload received at now, receivers in
+// Go can use it as is
+func Verify(secret, header string, payload []byte, now time.Time, tolerance time.Duration) error {
+	var t, v1 string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			v1 = value
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	signature, err := hex.DecodeString(v1)
+	if err != nil || !hmac.Equal(signature, mac(secret, t, payload)) {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrExpiredSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, payload []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return h.Sum(nil)
+}